@@ -0,0 +1,358 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// AsyncFullPolicyBlock blocks writer until buffer has space
+	AsyncFullPolicyBlock = "block"
+	// AsyncFullPolicyDropNewest drops the entry being written if buffer is full
+	AsyncFullPolicyDropNewest = "dropNewest"
+	// AsyncFullPolicyDropOldest drops the oldest entry in buffer to make space for the entry being written
+	AsyncFullPolicyDropOldest = "dropOldest"
+	// DefaultAsyncBufferSize is the number of entries buffered if bufferSize was not provided
+	DefaultAsyncBufferSize = 4096
+)
+
+// asyncShutdownWriters are open writers and whether they should be flushed at signals
+var (
+	asyncShutdownLock    sync.Mutex
+	asyncShutdownWriters = make(map[*AsyncWriteSyncer]bool)
+	asyncShutdownSignals chan os.Signal
+
+	// asyncRaiseSignal is replaceable in unit tests
+	asyncRaiseSignal = raiseSignal
+)
+
+// AsyncWriterConfig is the config of AsyncWriteSyncer.
+//
+// Example:
+// ---
+// bufferSize: 4096
+// fullPolicy: dropOldest
+// flushOnShutdown: true
+type AsyncWriterConfig struct {
+	// BufferSize is the maximum number of entries waiting to be written, DefaultAsyncBufferSize would be used if zero
+	BufferSize int `yaml:"bufferSize" json:"bufferSize" mapstructure:"bufferSize"`
+	// FullPolicy is the policy when buffer is full, could be block, dropNewest and dropOldest, block is the default
+	FullPolicy string `yaml:"fullPolicy" json:"fullPolicy" mapstructure:"fullPolicy"`
+	// FlushOnShutdown flushes buffer when SIGINT or SIGTERM is received, FlushAsyncWriteSyncers flushes it as well
+	FlushOnShutdown bool `yaml:"flushOnShutdown" json:"flushOnShutdown" mapstructure:"flushOnShutdown"`
+}
+
+// AsyncWriteSyncer is a zapcore.WriteSyncer which writes into underlying zapcore.WriteSyncer in background.
+//
+// Entries are copied into a bounded buffer and written in order by a single goroutine, Sync blocks until buffered
+// entries are written.
+type AsyncWriteSyncer struct {
+	ws     zapcore.WriteSyncer
+	size   int
+	policy string
+
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	drained  *sync.Cond
+	queue    [][]byte
+	writing  bool
+	closed   bool
+	err      error
+	done     chan struct{}
+
+	dropped uint64
+}
+
+// NewAsyncWriteSyncer wraps ws with AsyncWriteSyncer and starts background goroutine.
+func NewAsyncWriteSyncer(ws zapcore.WriteSyncer, config *AsyncWriterConfig) (*AsyncWriteSyncer, error) {
+	if ws == nil {
+		return nil, errors.New("nil write syncer")
+	}
+
+	if config == nil {
+		config = &AsyncWriterConfig{}
+	}
+
+	if config.BufferSize < 0 {
+		return nil, fmt.Errorf("negative bufferSize %d not allowed", config.BufferSize)
+	}
+
+	w := &AsyncWriteSyncer{
+		ws:     ws,
+		size:   config.BufferSize,
+		policy: config.FullPolicy,
+		done:   make(chan struct{}),
+	}
+
+	if w.size == 0 {
+		w.size = DefaultAsyncBufferSize
+	}
+
+	switch w.policy {
+	case "":
+		w.policy = AsyncFullPolicyBlock
+	case AsyncFullPolicyBlock, AsyncFullPolicyDropNewest, AsyncFullPolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("invalid fullPolicy %q, available policies are %s, %s and %s", w.policy,
+			AsyncFullPolicyBlock, AsyncFullPolicyDropNewest, AsyncFullPolicyDropOldest)
+	}
+
+	w.notEmpty = sync.NewCond(&w.lock)
+	w.notFull = sync.NewCond(&w.lock)
+	w.drained = sync.NewCond(&w.lock)
+
+	go w.run()
+
+	asyncShutdownLock.Lock()
+	asyncShutdownWriters[w] = config.FlushOnShutdown
+	if config.FlushOnShutdown {
+		armAsyncShutdownSignals()
+	}
+	asyncShutdownLock.Unlock()
+
+	return w, nil
+}
+
+// Write copies p into buffer, buffer is handled based on policy if it is full.
+func (w *AsyncWriteSyncer) Write(p []byte) (int, error) {
+	// zap reuses buffer after Write returns
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for !w.closed && len(w.queue) >= w.size {
+		switch w.policy {
+		case AsyncFullPolicyDropNewest:
+			atomic.AddUint64(&w.dropped, 1)
+			return len(p), nil
+		case AsyncFullPolicyDropOldest:
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+			atomic.AddUint64(&w.dropped, 1)
+		default:
+			w.notFull.Wait()
+		}
+	}
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	w.queue = append(w.queue, entry)
+	w.notEmpty.Signal()
+
+	return len(p), nil
+}
+
+// Sync blocks until buffered entries are written and syncs underlying zapcore.WriteSyncer.
+//
+// The first error occurred while writing in background since last Sync would be returned.
+func (w *AsyncWriteSyncer) Sync() error {
+	w.lock.Lock()
+	for len(w.queue) > 0 || w.writing {
+		w.drained.Wait()
+	}
+	err := w.err
+	w.err = nil
+	w.lock.Unlock()
+
+	if syncErr := w.ws.Sync(); err == nil {
+		err = syncErr
+	}
+
+	return err
+}
+
+// Close flushes buffer and stops background goroutine, underlying zapcore.WriteSyncer would be closed if it
+// implements io.Closer.
+func (w *AsyncWriteSyncer) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.notEmpty.Broadcast()
+	w.notFull.Broadcast()
+	w.lock.Unlock()
+
+	<-w.done
+	asyncShutdownLock.Lock()
+	delete(asyncShutdownWriters, w)
+	disarmAsyncShutdownSignals()
+	asyncShutdownLock.Unlock()
+
+	err := w.Sync()
+	if closer, ok := w.ws.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Dropped returns the number of entries dropped because buffer was full.
+func (w *AsyncWriteSyncer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Buffered returns the number of entries waiting to be written.
+func (w *AsyncWriteSyncer) Buffered() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return len(w.queue)
+}
+
+// run writes buffered entries in batches until closed and drained.
+func (w *AsyncWriteSyncer) run() {
+	defer close(w.done)
+
+	for {
+		w.lock.Lock()
+		for len(w.queue) < 1 && !w.closed {
+			w.notEmpty.Wait()
+		}
+
+		if len(w.queue) < 1 {
+			w.lock.Unlock()
+			return
+		}
+
+		batch := w.queue
+		w.queue = nil
+		w.writing = true
+		w.notFull.Broadcast()
+		w.lock.Unlock()
+
+		var err error
+		for i := range batch {
+			if _, writeErr := w.ws.Write(batch[i]); err == nil {
+				err = writeErr
+			}
+		}
+
+		w.lock.Lock()
+		w.writing = false
+		if w.err == nil {
+			w.err = err
+		}
+		if len(w.queue) < 1 {
+			w.drained.Broadcast()
+		}
+		w.lock.Unlock()
+	}
+}
+
+// FlushAsyncWriteSyncers syncs all open writers, the first error would be returned.
+//
+// Writers are flushed at SIGINT and SIGTERM already, this could be called in shutdown hook of application which
+// exits without signals.
+func FlushAsyncWriteSyncers() error {
+	asyncShutdownLock.Lock()
+	writers := make([]*AsyncWriteSyncer, 0, len(asyncShutdownWriters))
+	for w := range asyncShutdownWriters {
+		writers = append(writers, w)
+	}
+	asyncShutdownLock.Unlock()
+
+	var err error
+	for _, w := range writers {
+		if syncErr := w.Sync(); err == nil {
+			err = syncErr
+		}
+	}
+
+	return err
+}
+
+// armAsyncShutdownSignals starts listening SIGINT and SIGTERM if not started, asyncShutdownLock should be held.
+func armAsyncShutdownSignals() {
+	if asyncShutdownSignals != nil {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	asyncShutdownSignals = ch
+
+	go handleAsyncShutdownSignal(ch)
+}
+
+// disarmAsyncShutdownSignals stops listening signals if no open writer should be flushed at signals,
+// asyncShutdownLock should be held.
+func disarmAsyncShutdownSignals() {
+	if asyncShutdownSignals == nil {
+		return
+	}
+
+	for _, flushOnShutdown := range asyncShutdownWriters {
+		if flushOnShutdown {
+			return
+		}
+	}
+
+	stopAsyncShutdownSignals()
+}
+
+// stopAsyncShutdownSignals stops listening signals, asyncShutdownLock should be held.
+func stopAsyncShutdownSignals() {
+	if asyncShutdownSignals == nil {
+		return
+	}
+
+	signal.Stop(asyncShutdownSignals)
+	close(asyncShutdownSignals)
+	asyncShutdownSignals = nil
+}
+
+// handleAsyncShutdownSignal flushes writers at signal and raises it again.
+//
+// Signal is raised after listening stopped, so that the default action which exits process would take effect.
+// Applications listening these signals by themselves would receive it twice, they could disable FlushOnShutdown and
+// call FlushAsyncWriteSyncers in their shutdown hooks instead.
+func handleAsyncShutdownSignal(ch chan os.Signal) {
+	sig, ok := <-ch
+	if !ok {
+		return
+	}
+
+	// errors are ignored since there is no place to report them
+	FlushAsyncWriteSyncers()
+
+	asyncShutdownLock.Lock()
+	if asyncShutdownSignals == ch {
+		stopAsyncShutdownSignals()
+	}
+	asyncShutdownLock.Unlock()
+
+	asyncRaiseSignal(sig)
+}
+
+// raiseSignal sends sig to current process, process exits if it could not be sent.
+func raiseSignal(sig os.Signal) {
+	proc, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = proc.Signal(sig)
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blockingWriteSyncer blocks Write until release is closed.
+type blockingWriteSyncer struct {
+	lock    sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+	err     error
+	synced  int
+	closed  bool
+}
+
+func (w *blockingWriteSyncer) Write(p []byte) (int, error) {
+	<-w.release
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf.Write(p)
+	return len(p), w.err
+}
+
+func (w *blockingWriteSyncer) Sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.synced++
+	return nil
+}
+
+func (w *blockingWriteSyncer) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *blockingWriteSyncer) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+func newBlockingWriteSyncer(released bool) *blockingWriteSyncer {
+	w := &blockingWriteSyncer{release: make(chan struct{})}
+	if released {
+		close(w.release)
+	}
+
+	return w
+}
+
+func TestNewAsyncWriteSyncer_WithInvalidConfig(t *testing.T) {
+	_, err := NewAsyncWriteSyncer(nil, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewAsyncWriteSyncer(newBlockingWriteSyncer(true), &AsyncWriterConfig{BufferSize: -1})
+	assert.NotNil(t, err)
+
+	_, err = NewAsyncWriteSyncer(newBlockingWriteSyncer(true), &AsyncWriterConfig{FullPolicy: "ut"})
+	assert.NotNil(t, err)
+}
+
+func TestAsyncWriteSyncer_HappyCase(t *testing.T) {
+	ws := newBlockingWriteSyncer(true)
+	w, err := NewAsyncWriteSyncer(ws, nil)
+	assert.Nil(t, err)
+
+	p := []byte("a")
+	for i := 0; i < 100; i++ {
+		_, err = w.Write(p)
+		assert.Nil(t, err)
+	}
+	// buffer should be copied since zap reuses it
+	p[0] = 'b'
+
+	assert.Nil(t, w.Sync())
+	assert.Equal(t, strings.Repeat("a", 100), ws.String())
+	assert.Equal(t, 0, w.Buffered())
+	assert.Equal(t, 1, ws.synced)
+
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close())
+	assert.True(t, ws.closed)
+
+	_, err = w.Write(p)
+	assert.NotNil(t, err)
+}
+
+// fillAsyncWriteSyncer writes 0 which blocks the background goroutine, then writes 1..n into buffer.
+func fillAsyncWriteSyncer(t *testing.T, w *AsyncWriteSyncer, n int) {
+	_, err := w.Write([]byte("0"))
+	assert.Nil(t, err)
+
+	// wait for background goroutine taking 0
+	for w.Buffered() > 0 {
+		runtime.Gosched()
+	}
+
+	for i := 1; i <= n; i++ {
+		_, err = w.Write([]byte(strconv.Itoa(i)))
+		assert.Nil(t, err)
+	}
+}
+
+func TestAsyncWriteSyncer_WithDropNewest(t *testing.T) {
+	ws := newBlockingWriteSyncer(false)
+	w, err := NewAsyncWriteSyncer(ws, &AsyncWriterConfig{BufferSize: 2, FullPolicy: AsyncFullPolicyDropNewest})
+	assert.Nil(t, err)
+
+	fillAsyncWriteSyncer(t, w, 4)
+	assert.Equal(t, uint64(2), w.Dropped())
+
+	close(ws.release)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, "012", ws.String())
+}
+
+func TestAsyncWriteSyncer_WithDropOldest(t *testing.T) {
+	ws := newBlockingWriteSyncer(false)
+	w, err := NewAsyncWriteSyncer(ws, &AsyncWriterConfig{BufferSize: 2, FullPolicy: AsyncFullPolicyDropOldest})
+	assert.Nil(t, err)
+
+	fillAsyncWriteSyncer(t, w, 4)
+	assert.Equal(t, uint64(2), w.Dropped())
+
+	close(ws.release)
+	assert.Nil(t, w.Close())
+	assert.Equal(t, "034", ws.String())
+}
+
+func TestAsyncWriteSyncer_WithBlock(t *testing.T) {
+	ws := newBlockingWriteSyncer(false)
+	w, err := NewAsyncWriteSyncer(ws, &AsyncWriterConfig{BufferSize: 2})
+	assert.Nil(t, err)
+
+	fillAsyncWriteSyncer(t, w, 2)
+
+	done := make(chan struct{})
+	go func() {
+		_, err := w.Write([]byte("3"))
+		assert.Nil(t, err)
+		close(done)
+	}()
+
+	close(ws.release)
+	<-done
+	assert.Nil(t, w.Close())
+	assert.Equal(t, "0123", ws.String())
+	assert.Equal(t, uint64(0), w.Dropped())
+}
+
+func TestAsyncWriteSyncer_WithWriteError(t *testing.T) {
+	ws := newBlockingWriteSyncer(true)
+	ws.err = errors.New("ut-error")
+	w, err := NewAsyncWriteSyncer(ws, nil)
+	assert.Nil(t, err)
+
+	_, err = w.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.NotNil(t, w.Sync())
+	// error is reset after Sync
+	assert.Nil(t, w.Sync())
+	assert.Nil(t, w.Close())
+}
+
+func TestFlushAsyncWriteSyncers(t *testing.T) {
+	// Writers without FlushOnShutdown should be flushed as well
+	ws := newBlockingWriteSyncer(true)
+	w, err := NewAsyncWriteSyncer(ws, &AsyncWriterConfig{})
+	assert.Nil(t, err)
+
+	_, err = w.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, FlushAsyncWriteSyncers())
+	assert.Equal(t, "a", ws.String())
+
+	assert.Nil(t, w.Close())
+	assert.NotContains(t, asyncShutdownWriters, w)
+}
+
+func TestAsyncWriteSyncer_WithShutdownSignal(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer func(origin func(os.Signal)) { asyncRaiseSignal = origin }(asyncRaiseSignal)
+	asyncRaiseSignal = func(sig os.Signal) { raised <- sig }
+
+	ws := newBlockingWriteSyncer(true)
+	w, err := NewAsyncWriteSyncer(ws, &AsyncWriterConfig{FlushOnShutdown: true})
+	assert.Nil(t, err)
+	defer w.Close()
+
+	_, err = w.Write([]byte("a"))
+	assert.Nil(t, err)
+
+	proc, err := os.FindProcess(os.Getpid())
+	assert.Nil(t, err)
+	assert.Nil(t, proc.Signal(syscall.SIGTERM))
+
+	// Signal should be raised again after buffer was flushed
+	select {
+	case sig := <-raised:
+		assert.Equal(t, syscall.SIGTERM, sig)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "signal not raised")
+	}
+	assert.Equal(t, "a", ws.String())
+
+	asyncShutdownLock.Lock()
+	assert.Nil(t, asyncShutdownSignals)
+	asyncShutdownLock.Unlock()
+}
+
+func TestNewZapLoggerFromConfig_WithAsync(t *testing.T) {
+	dir := t.TempDir()
+	config := &ZapLoggerConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+zap:
+  outputPaths: ["`+path.Join(dir, "app.log")+`"]
+async:
+  bufferSize: 16
+  fullPolicy: dropOldest
+`), config))
+
+	logger, err := NewZapLoggerFromConfig(config)
+	assert.Nil(t, err)
+
+	sink := fileSinks[path.Join(dir, "app.log")]
+	assert.NotNil(t, sink)
+	async, ok := sink.writer.(*AsyncWriteSyncer)
+	assert.True(t, ok)
+	assert.Equal(t, 16, async.size)
+	assert.Equal(t, AsyncFullPolicyDropOldest, async.policy)
+
+	logger.Info("ut-message")
+	assert.Nil(t, logger.Sync())
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, "app.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "ut-message")
+	assert.Nil(t, sink.Close())
+}
+
+func TestNewLumberjackSink_WithInvalidAsyncQuery(t *testing.T) {
+	_, err := newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: "/ut/app.log", RawQuery: "async=x"})
+	assert.NotNil(t, err)
+
+	_, err = newLumberjackSink(&url.URL{
+		Scheme:   LumberjackSinkScheme,
+		Path:     "/ut/app.log",
+		RawQuery: "async=true&asyncFullPolicy=ut",
+	})
+	assert.NotNil(t, err)
+}
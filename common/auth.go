@@ -0,0 +1,354 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	rkerror "github.com/rookie-ninja/rk-common/error"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// BasicAuthHeaderKey is the HTTP header of basic auth
+	BasicAuthHeaderKey = "Authorization"
+	// DefaultBasicAuthRealm is the realm in WWW-Authenticate header if realm is empty
+	DefaultBasicAuthRealm = "rk"
+
+	basicAuthPrefix = "basic "
+)
+
+// BasicAuthConfig is a YAML decodable config of CredentialStore.
+//
+// Credentials are formed as <username>:<password>, password could be plain text, bcrypt hash like $2a$10$... or
+// argon2 hash like $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash> in PHC format.
+//
+// Example:
+// ---
+// realm: my-service
+// credentials:
+//   - "admin:$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+type BasicAuthConfig struct {
+	// Realm in WWW-Authenticate header, DefaultBasicAuthRealm would be used if empty
+	Realm string `yaml:"realm" json:"realm"`
+	// Credentials formed as <username>:<password>
+	Credentials []string `yaml:"credentials" json:"credentials"`
+}
+
+// ParseBasicAuth parses basic auth formed as <username>:<password> or header value formed as Basic <base64>.
+//
+// Password is everything after the first colon, so that it could contain colons. ok would be false if auth is
+// malformed or username is empty.
+func ParseBasicAuth(auth string) (username, password string, ok bool) {
+	auth = strings.TrimSpace(auth)
+	if len(auth) > len(basicAuthPrefix) && strings.EqualFold(auth[:len(basicAuthPrefix)], basicAuthPrefix) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(basicAuthPrefix):]))
+		if err != nil {
+			return "", "", false
+		}
+		auth = string(decoded)
+	}
+
+	i := strings.Index(auth, ":")
+	if i < 1 {
+		return "", "", false
+	}
+
+	return auth[:i], auth[i+1:], true
+}
+
+// credential verifies password of a user.
+type credential interface {
+	verify(password string) bool
+	// cost is the rough relative cost of verify, bcrypt with cost 10 and argon2 with 64MiB and t=1 are similar
+	cost() uint64
+	// dummy returns credential with random secret which costs the same as verify
+	dummy() (credential, error)
+}
+
+// plainCredential compares sha256 digests, so that comparison is constant time regardless of length.
+type plainCredential struct {
+	digest [sha256.Size]byte
+}
+
+func (c *plainCredential) verify(password string) bool {
+	digest := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(c.digest[:], digest[:]) == 1
+}
+
+func (c *plainCredential) cost() uint64 {
+	return 0
+}
+
+func (c *plainCredential) dummy() (credential, error) {
+	return &plainCredential{}, nil
+}
+
+type bcryptCredential struct {
+	hash []byte
+}
+
+func (c *bcryptCredential) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(c.hash, []byte(password)) == nil
+}
+
+func (c *bcryptCredential) cost() uint64 {
+	cost, _ := bcrypt.Cost(c.hash)
+	return 1 << uint(cost)
+}
+
+func (c *bcryptCredential) dummy() (credential, error) {
+	cost, err := bcrypt.Cost(c.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(secret, cost)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bcryptCredential{hash: hash}, nil
+}
+
+type argon2Credential struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (c *argon2Credential) verify(password string) bool {
+	var key []byte
+	if c.variant == "argon2id" {
+		key = argon2.IDKey([]byte(password), c.salt, c.time, c.memory, c.threads, uint32(len(c.key)))
+	} else {
+		key = argon2.Key([]byte(password), c.salt, c.time, c.memory, c.threads, uint32(len(c.key)))
+	}
+
+	return subtle.ConstantTimeCompare(c.key, key) == 1
+}
+
+func (c *argon2Credential) cost() uint64 {
+	return uint64(c.time) * uint64(c.memory) / 64
+}
+
+func (c *argon2Credential) dummy() (credential, error) {
+	res := *c
+	res.salt = make([]byte, len(c.salt))
+	if _, err := rand.Read(res.salt); err != nil {
+		return nil, err
+	}
+	res.key = make([]byte, len(c.key))
+
+	return &res, nil
+}
+
+// parseArgon2Credential parses argon2 hash in PHC format like $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>.
+func parseArgon2Credential(hash string) (*argon2Credential, error) {
+	tokens := strings.Split(hash, "$")
+	if len(tokens) != 6 || (tokens[1] != "argon2id" && tokens[1] != "argon2i") {
+		return nil, errors.New("invalid argon2 hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(tokens[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", tokens[2])
+	}
+
+	res := &argon2Credential{variant: tokens[1]}
+	if _, err := fmt.Sscanf(tokens[3], "m=%d,t=%d,p=%d", &res.memory, &res.time, &res.threads); err != nil ||
+		res.time < 1 || res.threads < 1 {
+		return nil, fmt.Errorf("invalid argon2 params %q", tokens[3])
+	}
+
+	var err error
+	if res.salt, err = base64.RawStdEncoding.DecodeString(tokens[4]); err != nil {
+		return nil, errors.New("invalid salt of argon2 hash")
+	}
+	if res.key, err = base64.RawStdEncoding.DecodeString(tokens[5]); err != nil || len(res.key) < 1 {
+		return nil, errors.New("invalid key of argon2 hash")
+	}
+
+	return res, nil
+}
+
+// newCredential creates credential with plain text password or hash.
+func newCredential(secret string) (credential, error) {
+	switch {
+	case strings.HasPrefix(secret, "$2a$") || strings.HasPrefix(secret, "$2b$") || strings.HasPrefix(secret, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(secret)); err != nil {
+			return nil, err
+		}
+		return &bcryptCredential{hash: []byte(secret)}, nil
+	case strings.HasPrefix(secret, "$argon2"):
+		return parseArgon2Credential(secret)
+	}
+
+	return &plainCredential{digest: sha256.Sum256([]byte(secret))}, nil
+}
+
+// HashPasswordWithArgon2id returns argon2id hash of password in PHC format with random salt, which could be used
+// in credentials of BasicAuthConfig.
+func HashPasswordWithArgon2id(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var memory, time uint32 = 64 * 1024, 1
+	var threads uint8 = 4
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, 32)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, time, threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CredentialStore keeps credentials of basic auth, it is safe for concurrent use.
+type CredentialStore struct {
+	realm string
+
+	lock        sync.RWMutex
+	credentials map[string]credential
+	// dummy is verified for unknown users, it costs as much as the most expensive credential ever added
+	dummy credential
+}
+
+// NewCredentialStore returns CredentialStore with credentials in config, empty store would be returned if config
+// is nil.
+func NewCredentialStore(config *BasicAuthConfig) (*CredentialStore, error) {
+	if config == nil {
+		config = &BasicAuthConfig{}
+	}
+
+	store := &CredentialStore{
+		realm:       GetDefaultIfEmptyString(config.Realm, DefaultBasicAuthRealm),
+		credentials: make(map[string]credential),
+		dummy:       &plainCredential{},
+	}
+
+	for i := range config.Credentials {
+		username, secret, ok := ParseBasicAuth(config.Credentials[i])
+		if !ok {
+			return nil, fmt.Errorf("invalid credentials[%d], should be formed as <username>:<password>", i)
+		}
+
+		if err := store.Add(username, secret); err != nil {
+			return nil, fmt.Errorf("invalid credentials[%d], %v", i, err)
+		}
+	}
+
+	return store, nil
+}
+
+// Realm returns realm of store.
+func (s *CredentialStore) Realm() string {
+	return s.realm
+}
+
+// Add adds or replaces credential of user, secret could be plain text password, bcrypt or argon2 hash.
+func (s *CredentialStore) Add(username, secret string) error {
+	if len(username) < 1 {
+		return errors.New("empty username")
+	}
+
+	cred, err := newCredential(secret)
+	if err != nil {
+		return err
+	}
+
+	s.lock.RLock()
+	moreExpensive := cred.cost() > s.dummy.cost()
+	s.lock.RUnlock()
+
+	// generate dummy outside of lock, since bcrypt hash is slow
+	var dummy credential
+	if moreExpensive {
+		if dummy, err = cred.dummy(); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credentials[username] = cred
+	if dummy != nil && dummy.cost() > s.dummy.cost() {
+		s.dummy = dummy
+	}
+
+	return nil
+}
+
+// Authenticate returns true if password matched with credential of user.
+func (s *CredentialStore) Authenticate(username, password string) bool {
+	s.lock.RLock()
+	cred, ok := s.credentials[username]
+	dummy := s.dummy
+	s.lock.RUnlock()
+
+	if !ok {
+		// verify anyway with dummy as expensive as the most expensive credential, so that existence of users
+		// could not be told from response time
+		dummy.verify(password)
+		return false
+	}
+
+	return cred.verify(password)
+}
+
+// AuthenticateHeader parses value of Authorization header formed as Basic <base64> and returns username if
+// authenticated. Raw <username>:<password> accepted by ParseBasicAuth is rejected, since it is not a valid header.
+func (s *CredentialStore) AuthenticateHeader(auth string) (string, bool) {
+	auth = strings.TrimSpace(auth)
+	if len(auth) <= len(basicAuthPrefix) || !strings.EqualFold(auth[:len(basicAuthPrefix)], basicAuthPrefix) {
+		return "", false
+	}
+
+	username, password, ok := ParseBasicAuth(auth)
+	if !ok || !s.Authenticate(username, password) {
+		return "", false
+	}
+
+	return username, true
+}
+
+// NewBasicAuthHttpMiddleware returns handler which authenticates Authorization header of requests with store,
+// header should be formed as Basic <base64>.
+//
+// Unauthenticated requests are responded with 401, WWW-Authenticate header and rkerror.ErrorResp in JSON.
+// Username of authenticated requests is added into context as zap field "username".
+func NewBasicAuthHttpMiddleware(store *CredentialStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		username, ok := store.AuthenticateHeader(req.Header.Get(BasicAuthHeaderKey))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, store.Realm()))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(ConvertStructToBytes(rkerror.New(
+				rkerror.WithHttpCode(http.StatusUnauthorized),
+				rkerror.WithMessage("invalid basic auth"))))
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(ContextWithZapFields(req.Context(), zap.String("username", username))))
+	})
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseBasicAuth(t *testing.T) {
+	for _, tc := range []struct {
+		auth     string
+		username string
+		password string
+	}{
+		{"user:pass", "user", "pass"},
+		{"user:pa:ss", "user", "pa:ss"},
+		{"user:", "user", ""},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("user:pa:ss")), "user", "pa:ss"},
+		{"  basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")), "user", "pass"},
+	} {
+		username, password, ok := ParseBasicAuth(tc.auth)
+		assert.True(t, ok, tc.auth)
+		assert.Equal(t, tc.username, username)
+		assert.Equal(t, tc.password, password)
+	}
+
+	for _, invalid := range []string{"", "user", ":pass", "Basic !!!", "Basic " + base64.StdEncoding.EncodeToString([]byte("user"))} {
+		_, _, ok := ParseBasicAuth(invalid)
+		assert.False(t, ok, invalid)
+	}
+
+	assert.Equal(t, "pa:ss", GetPasswordFromBasicAuthString("user:pa:ss"))
+	assert.Equal(t, "user", GetUsernameFromBasicAuthString("user:pa:ss"))
+}
+
+func TestNewCredentialStore(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	argon2Hash, err := HashPasswordWithArgon2id("argon2-pass")
+	assert.Nil(t, err)
+
+	config := &BasicAuthConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+realm: ut-realm
+credentials:
+  - "plain:pa:ss"
+  - "bcrypt:`+string(bcryptHash)+`"
+  - "argon2:`+argon2Hash+`"
+`), config))
+
+	store, err := NewCredentialStore(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "ut-realm", store.Realm())
+
+	assert.True(t, store.Authenticate("plain", "pa:ss"))
+	assert.True(t, store.Authenticate("bcrypt", "bcrypt-pass"))
+	assert.True(t, store.Authenticate("argon2", "argon2-pass"))
+
+	assert.False(t, store.Authenticate("plain", "pa"))
+	assert.False(t, store.Authenticate("bcrypt", "wrong"))
+	assert.False(t, store.Authenticate("argon2", "wrong"))
+	assert.False(t, store.Authenticate("absent", "pa:ss"))
+
+	username, ok := store.AuthenticateHeader("Basic " + base64.StdEncoding.EncodeToString([]byte("plain:pa:ss")))
+	assert.True(t, ok)
+	assert.Equal(t, "plain", username)
+
+	// header without Basic scheme
+	_, ok = store.AuthenticateHeader("plain:pa:ss")
+	assert.False(t, ok)
+	_, ok = store.AuthenticateHeader("Bearer " + base64.StdEncoding.EncodeToString([]byte("plain:pa:ss")))
+	assert.False(t, ok)
+
+	// With invalid config
+	for _, credential := range []string{
+		"no-colon",
+		"bcrypt:$2a$xx",
+		"argon2:$argon2id$v=19$m=65536,t=1,p=4$salt",
+		"argon2:$argon2id$v=18$m=65536,t=1,p=4$c2FsdA$a2V5",
+		"argon2:$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+		"argon2:$argon2id$v=19$m=65536,t=1,p=4$!$a2V5",
+	} {
+		_, err = NewCredentialStore(&BasicAuthConfig{Credentials: []string{credential}})
+		assert.NotNil(t, err, credential)
+	}
+
+	store, err = NewCredentialStore(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultBasicAuthRealm, store.Realm())
+	assert.NotNil(t, store.Add("", "pass"))
+}
+
+func TestCredentialStore_Dummy(t *testing.T) {
+	store, err := NewCredentialStore(&BasicAuthConfig{Credentials: []string{"plain:pass"}})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), store.dummy.cost())
+
+	// dummy follows the most expensive credential
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost+1)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Add("bcrypt", string(bcryptHash)))
+	dummy, ok := store.dummy.(*bcryptCredential)
+	assert.True(t, ok)
+	cost, err := bcrypt.Cost(dummy.hash)
+	assert.Nil(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	// cheaper credential does not replace dummy
+	bcryptHash, err = bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Add("cheap", string(bcryptHash)))
+	assert.Equal(t, dummy, store.dummy)
+
+	assert.Nil(t, store.Add("argon2", "$argon2id$v=19$m=65536,t=2,p=4$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5"))
+	argon2Dummy, ok := store.dummy.(*argon2Credential)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(65536), argon2Dummy.memory)
+	assert.Equal(t, uint32(2), argon2Dummy.time)
+	assert.Len(t, argon2Dummy.key, 12)
+	assert.NotEqual(t, []byte("saltsalt"), argon2Dummy.salt)
+
+	assert.False(t, store.Authenticate("absent", "pass"))
+}
+
+func TestNewBasicAuthHttpMiddleware(t *testing.T) {
+	store, err := NewCredentialStore(&BasicAuthConfig{Credentials: []string{"user:pass"}})
+	assert.Nil(t, err)
+
+	var ctx context.Context
+	handler := NewBasicAuthHttpMiddleware(store, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx = req.Context()
+	}))
+
+	// Without credential
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, `Basic realm="rk", charset="UTF-8"`, resp.Header().Get("WWW-Authenticate"))
+	assert.Contains(t, resp.Body.String(), "invalid basic auth")
+	assert.Nil(t, ctx)
+
+	// With raw credential without Basic scheme
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(BasicAuthHeaderKey, "user:pass")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Nil(t, ctx)
+
+	// With credential
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", "pass")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, GetZapFieldsFromContext(ctx), 1)
+	assert.Equal(t, "username", GetZapFieldsFromContext(ctx)[0].Key)
+}
@@ -11,28 +11,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"io/ioutil"
-	"math/rand"
-	"net"
 	"os"
 	"path"
 	"reflect"
-	"regexp"
 	"strings"
 )
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-// RandString generate random string.
+// RandString generate random string of letters with FastRandString.
+// The result is predictable, use SecureRandString for tokens and passwords.
 func RandString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
+	return FastRandString(n, AlphabetLetters)
 }
 
 // OverrideLumberjackConfig override lumberjack config.
@@ -60,98 +53,275 @@ func OverrideLumberjackConfig(origin *lumberjack.Logger, override *lumberjack.Lo
 	}
 }
 
+// ZapConfigOverride is a field-presence-aware override of zap.Config which could be decoded from YAML or JSON.
+//
+// Keys are the same as zap.Config. Nil fields are treated as not set and would not be overridden, so that zero
+// values like false or empty string could still be used as override. Slices and maps are treated as not set
+// only if they are nil, an explicit empty list in YAML clears the origin.
+//
+// EncodingPreset is the name of EncodingPreset which would be applied before Encoding and EncoderConfig, so that
+// a preset could still be adjusted with other fields.
+type ZapConfigOverride struct {
+	Level             *zapcore.Level         `yaml:"level" json:"level" mapstructure:"level"`
+	Development       *bool                  `yaml:"development" json:"development" mapstructure:"development"`
+	DisableCaller     *bool                  `yaml:"disableCaller" json:"disableCaller" mapstructure:"disableCaller"`
+	DisableStacktrace *bool                  `yaml:"disableStacktrace" json:"disableStacktrace" mapstructure:"disableStacktrace"`
+	Sampling          *zap.SamplingConfig    `yaml:"sampling" json:"sampling" mapstructure:"sampling"`
+	Encoding          *string                `yaml:"encoding" json:"encoding" mapstructure:"encoding"`
+	EncodingPreset    *string                `yaml:"encodingPreset" json:"encodingPreset" mapstructure:"encodingPreset"`
+	EncoderConfig     *EncoderConfigOverride `yaml:"encoderConfig" json:"encoderConfig" mapstructure:"encoderConfig"`
+	OutputPaths       []string               `yaml:"outputPaths" json:"outputPaths" mapstructure:"outputPaths"`
+	ErrorOutputPaths  []string               `yaml:"errorOutputPaths" json:"errorOutputPaths" mapstructure:"errorOutputPaths"`
+	InitialFields     map[string]interface{} `yaml:"initialFields" json:"initialFields" mapstructure:"initialFields"`
+}
+
+// EncoderConfigOverride is a field-presence-aware override of zapcore.EncoderConfig.
+//
+// Keys are the same as zapcore.EncoderConfig. Nil fields are treated as not set and would not be overridden.
+type EncoderConfigOverride struct {
+	MessageKey          *string                                  `yaml:"messageKey" json:"messageKey" mapstructure:"messageKey"`
+	LevelKey            *string                                  `yaml:"levelKey" json:"levelKey" mapstructure:"levelKey"`
+	TimeKey             *string                                  `yaml:"timeKey" json:"timeKey" mapstructure:"timeKey"`
+	NameKey             *string                                  `yaml:"nameKey" json:"nameKey" mapstructure:"nameKey"`
+	CallerKey           *string                                  `yaml:"callerKey" json:"callerKey" mapstructure:"callerKey"`
+	FunctionKey         *string                                  `yaml:"functionKey" json:"functionKey" mapstructure:"functionKey"`
+	StacktraceKey       *string                                  `yaml:"stacktraceKey" json:"stacktraceKey" mapstructure:"stacktraceKey"`
+	SkipLineEnding      *bool                                    `yaml:"skipLineEnding" json:"skipLineEnding" mapstructure:"skipLineEnding"`
+	LineEnding          *string                                  `yaml:"lineEnding" json:"lineEnding" mapstructure:"lineEnding"`
+	EncodeLevel         *zapcore.LevelEncoder                    `yaml:"levelEncoder" json:"levelEncoder" mapstructure:"levelEncoder"`
+	EncodeTime          *zapcore.TimeEncoder                     `yaml:"timeEncoder" json:"timeEncoder" mapstructure:"timeEncoder"`
+	EncodeDuration      *zapcore.DurationEncoder                 `yaml:"durationEncoder" json:"durationEncoder" mapstructure:"durationEncoder"`
+	EncodeCaller        *zapcore.CallerEncoder                   `yaml:"callerEncoder" json:"callerEncoder" mapstructure:"callerEncoder"`
+	EncodeName          *zapcore.NameEncoder                     `yaml:"nameEncoder" json:"nameEncoder" mapstructure:"nameEncoder"`
+	NewReflectedEncoder func(io.Writer) zapcore.ReflectedEncoder `yaml:"-" json:"-" mapstructure:"-"`
+	ConsoleSeparator    *string                                  `yaml:"consoleSeparator" json:"consoleSeparator" mapstructure:"consoleSeparator"`
+}
+
+// NewZapConfigOverride converts config into override with the same semantics as the legacy OverrideZapConfig,
+// which is useful while migrating from OverrideZapConfig(origin, config).
+//
+// Development, DisableCaller and DisableStacktrace are always set, other fields are set only if non-zero, so that
+// empty strings, nil slices and nil encoders in config would not overwrite origin.
+func NewZapConfigOverride(config *zap.Config) *ZapConfigOverride {
+	if config == nil {
+		return nil
+	}
+
+	// take a copy so that override would not be changed along with config
+	copied := *config
+	config = &copied
+
+	override := &ZapConfigOverride{
+		Development:       &config.Development,
+		DisableCaller:     &config.DisableCaller,
+		DisableStacktrace: &config.DisableStacktrace,
+		Sampling:          config.Sampling,
+		Encoding:          nonEmptyStringPtr(&config.Encoding),
+	}
+
+	if config.Level != (zap.AtomicLevel{}) {
+		level := config.Level.Level()
+		override.Level = &level
+	}
+
+	if len(config.OutputPaths) > 0 {
+		override.OutputPaths = config.OutputPaths
+	}
+
+	if len(config.ErrorOutputPaths) > 0 {
+		override.ErrorOutputPaths = config.ErrorOutputPaths
+	}
+
+	if len(config.InitialFields) > 0 {
+		override.InitialFields = config.InitialFields
+	}
+
+	encoder := &config.EncoderConfig
+	override.EncoderConfig = &EncoderConfigOverride{
+		MessageKey:          nonEmptyStringPtr(&encoder.MessageKey),
+		LevelKey:            nonEmptyStringPtr(&encoder.LevelKey),
+		TimeKey:             nonEmptyStringPtr(&encoder.TimeKey),
+		NameKey:             nonEmptyStringPtr(&encoder.NameKey),
+		CallerKey:           nonEmptyStringPtr(&encoder.CallerKey),
+		FunctionKey:         nonEmptyStringPtr(&encoder.FunctionKey),
+		StacktraceKey:       nonEmptyStringPtr(&encoder.StacktraceKey),
+		LineEnding:          nonEmptyStringPtr(&encoder.LineEnding),
+		NewReflectedEncoder: encoder.NewReflectedEncoder,
+		ConsoleSeparator:    nonEmptyStringPtr(&encoder.ConsoleSeparator),
+	}
+
+	if encoder.SkipLineEnding {
+		override.EncoderConfig.SkipLineEnding = &encoder.SkipLineEnding
+	}
+
+	if encoder.EncodeLevel != nil {
+		override.EncoderConfig.EncodeLevel = &encoder.EncodeLevel
+	}
+
+	if encoder.EncodeTime != nil {
+		override.EncoderConfig.EncodeTime = &encoder.EncodeTime
+	}
+
+	if encoder.EncodeDuration != nil {
+		override.EncoderConfig.EncodeDuration = &encoder.EncodeDuration
+	}
+
+	if encoder.EncodeCaller != nil {
+		override.EncoderConfig.EncodeCaller = &encoder.EncodeCaller
+	}
+
+	if encoder.EncodeName != nil {
+		override.EncoderConfig.EncodeName = &encoder.EncodeName
+	}
+
+	return override
+}
+
+// nonEmptyStringPtr returns nil if string is empty, otherwise returns str.
+func nonEmptyStringPtr(str *string) *string {
+	if len(*str) < 1 {
+		return nil
+	}
+
+	return str
+}
+
 // OverrideZapConfig overrides zap config.
-// This function will override fields of non empty and non-nil.
-func OverrideZapConfig(origin *zap.Config, override *zap.Config) {
+// This function will override fields which are set in override, see ZapConfigOverride for details.
+//
+// If level of origin is zero value, a new atomic level would be created instead of calling SetLevel on it.
+// Origin would not be modified if error returned.
+func OverrideZapConfig(origin *zap.Config, override *ZapConfigOverride) error {
 	if override == nil {
-		return
+		return nil
 	}
 
-	// by default, these fields would be false
-	// so just override it with new config
-	origin.Development = override.Development
-	origin.DisableCaller = override.DisableCaller
-	origin.DisableStacktrace = override.DisableStacktrace
+	if origin == nil {
+		return errors.New("nil zap config to override")
+	}
 
-	if len(override.Encoding) > 0 {
-		origin.Encoding = override.Encoding
+	if override.Sampling != nil && (override.Sampling.Initial < 0 || override.Sampling.Thereafter < 0) {
+		return fmt.Errorf("negative sampling initial %d or thereafter %d not allowed",
+			override.Sampling.Initial, override.Sampling.Thereafter)
 	}
 
-	if !reflect.ValueOf(override.Level).Field(0).IsNil() {
-		origin.Level.SetLevel(override.Level.Level())
+	var preset *EncodingPreset
+	if override.EncodingPreset != nil {
+		var ok bool
+		if preset, ok = GetEncodingPreset(*override.EncodingPreset); !ok {
+			return fmt.Errorf("unknown encoding preset %q, available presets are %v",
+				*override.EncodingPreset, ListEncodingPresets())
+		}
 	}
 
-	if len(override.InitialFields) > 0 {
-		origin.InitialFields = override.InitialFields
+	for _, paths := range [][]string{override.OutputPaths, override.ErrorOutputPaths} {
+		for i := range paths {
+			if len(paths[i]) < 1 {
+				return errors.New("empty output path not allowed")
+			}
+		}
 	}
 
-	if len(override.ErrorOutputPaths) > 0 {
-		origin.ErrorOutputPaths = override.ErrorOutputPaths
+	if override.Level != nil {
+		// zero value of AtomicLevel would panic while calling SetLevel
+		if origin.Level == (zap.AtomicLevel{}) {
+			origin.Level = zap.NewAtomicLevelAt(*override.Level)
+		} else {
+			origin.Level.SetLevel(*override.Level)
+		}
 	}
 
-	if len(override.OutputPaths) > 0 {
-		origin.OutputPaths = override.OutputPaths
+	if preset != nil {
+		origin.Encoding = preset.Encoding
+		origin.EncoderConfig = preset.EncoderConfig
 	}
 
+	overrideBool(&origin.Development, override.Development)
+	overrideBool(&origin.DisableCaller, override.DisableCaller)
+	overrideBool(&origin.DisableStacktrace, override.DisableStacktrace)
+	overrideString(&origin.Encoding, override.Encoding)
+
 	if override.Sampling != nil {
 		origin.Sampling = override.Sampling
 	}
 
-	// deal with encoder config
-	if len(override.EncoderConfig.CallerKey) > 0 {
-		origin.EncoderConfig.CallerKey = override.EncoderConfig.CallerKey
+	if override.OutputPaths != nil {
+		origin.OutputPaths = override.OutputPaths
 	}
 
-	if len(override.EncoderConfig.ConsoleSeparator) > 0 {
-		origin.EncoderConfig.ConsoleSeparator = override.EncoderConfig.ConsoleSeparator
+	if override.ErrorOutputPaths != nil {
+		origin.ErrorOutputPaths = override.ErrorOutputPaths
 	}
 
-	if override.EncoderConfig.EncodeCaller != nil {
-		origin.EncoderConfig.EncodeCaller = override.EncoderConfig.EncodeCaller
+	if override.InitialFields != nil {
+		origin.InitialFields = override.InitialFields
 	}
 
-	if override.EncoderConfig.EncodeDuration != nil {
-		origin.EncoderConfig.EncodeDuration = override.EncoderConfig.EncodeDuration
+	// fields of preset are added if absent without modifying the map of origin
+	if preset != nil && len(preset.InitialFields) > 0 {
+		fields := make(map[string]interface{}, len(origin.InitialFields)+len(preset.InitialFields))
+		for k, v := range preset.InitialFields {
+			fields[k] = v
+		}
+		for k, v := range origin.InitialFields {
+			fields[k] = v
+		}
+		origin.InitialFields = fields
 	}
 
-	if override.EncoderConfig.EncodeLevel != nil {
-		origin.EncoderConfig.EncodeLevel = override.EncoderConfig.EncodeLevel
+	// deal with encoder config
+	encoder := override.EncoderConfig
+	if encoder == nil {
+		return nil
 	}
 
-	if override.EncoderConfig.EncodeName != nil {
-		origin.EncoderConfig.EncodeName = override.EncoderConfig.EncodeName
-	}
+	overrideString(&origin.EncoderConfig.MessageKey, encoder.MessageKey)
+	overrideString(&origin.EncoderConfig.LevelKey, encoder.LevelKey)
+	overrideString(&origin.EncoderConfig.TimeKey, encoder.TimeKey)
+	overrideString(&origin.EncoderConfig.NameKey, encoder.NameKey)
+	overrideString(&origin.EncoderConfig.CallerKey, encoder.CallerKey)
+	overrideString(&origin.EncoderConfig.FunctionKey, encoder.FunctionKey)
+	overrideString(&origin.EncoderConfig.StacktraceKey, encoder.StacktraceKey)
+	overrideBool(&origin.EncoderConfig.SkipLineEnding, encoder.SkipLineEnding)
+	overrideString(&origin.EncoderConfig.LineEnding, encoder.LineEnding)
+	overrideString(&origin.EncoderConfig.ConsoleSeparator, encoder.ConsoleSeparator)
 
-	if override.EncoderConfig.EncodeTime != nil {
-		origin.EncoderConfig.EncodeTime = override.EncoderConfig.EncodeTime
+	if encoder.EncodeLevel != nil {
+		origin.EncoderConfig.EncodeLevel = *encoder.EncodeLevel
 	}
 
-	if len(override.EncoderConfig.MessageKey) > 0 {
-		origin.EncoderConfig.MessageKey = override.EncoderConfig.MessageKey
+	if encoder.EncodeTime != nil {
+		origin.EncoderConfig.EncodeTime = *encoder.EncodeTime
 	}
 
-	if len(override.EncoderConfig.LevelKey) > 0 {
-		origin.EncoderConfig.LevelKey = override.EncoderConfig.LevelKey
+	if encoder.EncodeDuration != nil {
+		origin.EncoderConfig.EncodeDuration = *encoder.EncodeDuration
 	}
 
-	if len(override.EncoderConfig.TimeKey) > 0 {
-		origin.EncoderConfig.TimeKey = override.EncoderConfig.TimeKey
+	if encoder.EncodeCaller != nil {
+		origin.EncoderConfig.EncodeCaller = *encoder.EncodeCaller
 	}
 
-	if len(override.EncoderConfig.NameKey) > 0 {
-		origin.EncoderConfig.NameKey = override.EncoderConfig.NameKey
+	if encoder.EncodeName != nil {
+		origin.EncoderConfig.EncodeName = *encoder.EncodeName
 	}
 
-	if len(override.EncoderConfig.FunctionKey) > 0 {
-		origin.EncoderConfig.FunctionKey = override.EncoderConfig.FunctionKey
+	if encoder.NewReflectedEncoder != nil {
+		origin.EncoderConfig.NewReflectedEncoder = encoder.NewReflectedEncoder
 	}
 
-	if len(override.EncoderConfig.StacktraceKey) > 0 {
-		origin.EncoderConfig.StacktraceKey = override.EncoderConfig.StacktraceKey
+	return nil
+}
+
+func overrideBool(origin *bool, override *bool) {
+	if override != nil {
+		*origin = *override
 	}
+}
 
-	if len(override.EncoderConfig.LineEnding) > 0 {
-		origin.EncoderConfig.LineEnding = override.EncoderConfig.LineEnding
+func overrideString(origin *string, override *string) {
+	if override != nil {
+		*origin = *override
 	}
 }
 
@@ -251,33 +421,18 @@ func GetEnvValueOrDefault(key, defaultValue string) string {
 	return value
 }
 
-// GetLocalIP
-// This is a tricky function.
-// We will iterate through all the network interfaces，but will choose the first one since we are assuming that
-// eth0 will be the default one to use in most of the case.
+// GetLocalIP returns the preferred address of GetLocalIPWithOptions with default options, which is an address of
+// interface that is up and neither loopback, link-local nor virtual. IPv4 is preferred over IPv6.
 //
-// Currently, we do not have any interfaces for selecting the network interface yet.
+// "localhost" would be returned if no address found. Use GetLocalIPWithOptions to select interfaces.
 func GetLocalIP() string {
-	localIP := "localhost"
-
 	// skip the error since we don't want to break RPC calls because of it
-	addresses, err := net.InterfaceAddrs()
+	localIP, err := GetLocalIPWithOptions(nil)
 	if err != nil {
-		return localIP
+		return "localhost"
 	}
 
-	for _, addr := range addresses {
-		items := strings.Split(addr.String(), "/")
-		if len(items) < 2 || items[0] == "127.0.0.1" {
-			continue
-		}
-
-		if match, err := regexp.MatchString(`\d+\.\d+\.\d+\.\d+`, items[0]); err == nil && match {
-			localIP = items[0]
-		}
-	}
-
-	return localIP
+	return localIP.String()
 }
 
 // GetLocalHostname returns hostname of localhost, return "" if error occurs or hostname is empty.
@@ -290,40 +445,37 @@ func GetLocalHostname() string {
 	return hostname
 }
 
-// GenerateRequestId generate request id based on google/uuid.
-// UUIDs are based on RFC 4122 and DCE 1.1: Authentication and Security Services.
+// GenerateRequestId generate request id with generator set by SetRequestIdGenerator or ConfigureRequestIdGenerator.
+// UUIDv4 based on google/uuid would be used by default.
 //
-// A UUID is a 16 byte (128 bit) array. UUIDs may be used as keys to maps or compared directly.
+// Empty string would be returned if any error occurs, use GetRequestIdGenerator().Generate() to get the error.
 func GenerateRequestId() string {
-	// do not use uuid.New() since it would panic if any error occurs
-	requestId, err := uuid.NewRandom()
+	requestId, err := GetRequestIdGenerator().Generate()
 
 	// currently, we will return empty string if error occurs
 	if err != nil {
 		return ""
 	}
 
-	return requestId.String()
+	return requestId
 }
 
-// GenerateRequestIdWithPrefix generate request id based on google/uuid.
-// UUIDs are based on RFC 4122 and DCE 1.1: Authentication and Security Services.
+// GenerateRequestIdWithPrefix generate request id with GenerateRequestId and prefix joined by "-".
 //
-// A UUID is a 16 byte (128 bit) array. UUIDs may be used as keys to maps or compared directly.
+// Empty string would be returned if any error occurs.
 func GenerateRequestIdWithPrefix(prefix string) string {
-	// Do not use uuid.New() since it would panic if any error occurs
-	requestId, err := uuid.NewRandom()
+	requestId := GenerateRequestId()
 
 	// Currently, we will return empty string if error occurs
-	if err != nil {
+	if len(requestId) < 1 {
 		return ""
 	}
 
 	if len(prefix) > 0 {
-		return prefix + "-" + requestId.String()
+		return prefix + "-" + requestId
 	}
 
-	return requestId.String()
+	return requestId
 }
 
 // OverrideMap override source map with new map items.
@@ -444,20 +596,14 @@ func ConvertStructToMap(src interface{}) map[string]interface{} {
 	return res
 }
 
-// ConvertStructToZapFields convert struct to zap fields.
+// ConvertStructToZapFields convert struct to typed zap fields.
+// Fields are in declaration order of struct and sorted by key for map, json tags and omitempty are respected.
+// Nested structs and maps are converted into zap.Object, slices into zap.Array and values implementing
+// zapcore.ObjectMarshaler are used as they are.
+// Sensitive data is masked by DefaultRedactor, including fields tagged with `rk:"redact"`.
 // Return empty zap.Field array if input parameter is nil.
 func ConvertStructToZapFields(src interface{}) []zap.Field {
-	fields := make([]zap.Field, 0)
-	if src == nil {
-		return fields
-	}
-	mid := ConvertStructToMap(src)
-
-	for k, v := range mid {
-		fields = append(fields, zap.Any(k, v))
-	}
-
-	return fields
+	return convertToZapFields(src, DefaultRedactor)
 }
 
 // MatchLocaleWithEnv mainly used in entry config.
@@ -531,36 +677,33 @@ func MatchLocaleWithEnv(locale string) bool {
 	return localeFromEnv == locale || locale == "*::*::*::*"
 }
 
-// GetUsernameFromBasicAuthString extract username from basic auth formed as <username>:<password>
+// GetUsernameFromBasicAuthString extract username from basic auth formed as <username>:<password> or Basic <base64>,
+// see ParseBasicAuth.
 func GetUsernameFromBasicAuthString(basicAuth string) string {
-	tokens := strings.Split(basicAuth, ":")
-	if len(tokens) != 2 {
-		return ""
-	}
-
-	return tokens[0]
+	username, _, _ := ParseBasicAuth(basicAuth)
+	return username
 }
 
-// GetPasswordFromBasicAuthString extract password from basic auth formed as <username>:<password>
+// GetPasswordFromBasicAuthString extract password from basic auth formed as <username>:<password> or Basic <base64>,
+// password could contain colons, see ParseBasicAuth.
 func GetPasswordFromBasicAuthString(basicAuth string) string {
-	tokens := strings.Split(basicAuth, ":")
-	if len(tokens) != 2 {
-		return ""
-	}
-
-	return tokens[1]
+	_, password, _ := ParseBasicAuth(basicAuth)
+	return password
 }
 
-// ExtractSchemeFromURL extract scheme from endpoint
+// ExtractSchemeFromURL extract scheme from endpoint in lower case, empty string would be returned if scheme is
+// absent or not registered with RegisterScheme. Use ParseEndpoint to parse the whole endpoint.
 func ExtractSchemeFromURL(url string) string {
-	res := ""
-	if strings.HasPrefix(url, "http://") {
-		res = "http"
-	} else if strings.HasPrefix(url, "https://") {
-		res = "https"
+	i := strings.Index(url, "://")
+	if i < 1 {
+		return ""
 	}
 
-	return res
+	if _, ok := GetScheme(url[:i]); !ok {
+		return ""
+	}
+
+	return strings.ToLower(url[:i])
 }
 
 // GeneralizeMapKeyToString convert map key to printable one.
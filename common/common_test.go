@@ -6,11 +6,14 @@
 package rkcommon
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"gopkg.in/yaml.v2"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -56,7 +59,7 @@ func TestOverrideZapConfig_WithNilOverride(t *testing.T) {
 	originOne := &zap.Config{}
 	originTwo := &zap.Config{}
 
-	OverrideZapConfig(originOne, nil)
+	assert.Nil(t, OverrideZapConfig(originOne, nil))
 	assert.Equal(t, originOne, originTwo)
 }
 
@@ -64,10 +67,177 @@ func TestOverrideZapConfig_WithSame(t *testing.T) {
 	origin := &zap.Config{}
 	override := &zap.Config{}
 
-	OverrideZapConfig(origin, override)
+	assert.Nil(t, OverrideZapConfig(origin, NewZapConfigOverride(override)))
 	assert.Equal(t, origin, override)
 }
 
+func TestOverrideZapConfig_WithEmptyOverride(t *testing.T) {
+	origin := NewZapDefaultConfig()
+	expected := NewZapDefaultConfig()
+
+	assert.Nil(t, OverrideZapConfig(origin, &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{}}))
+	assert.Equal(t, expected.Level.Level(), origin.Level.Level())
+	assert.Equal(t, expected.Sampling, origin.Sampling)
+	assert.Equal(t, expected.OutputPaths, origin.OutputPaths)
+	assert.Equal(t, expected.EncoderConfig.MessageKey, origin.EncoderConfig.MessageKey)
+	assert.NotNil(t, origin.EncoderConfig.EncodeTime)
+}
+
+func TestOverrideZapConfig_WithInvalidOverride(t *testing.T) {
+	assert.NotNil(t, OverrideZapConfig(nil, &ZapConfigOverride{}))
+
+	origin := NewZapDefaultConfig()
+	assert.NotNil(t, OverrideZapConfig(origin, &ZapConfigOverride{
+		Encoding: stringPtr("json"),
+		Sampling: &zap.SamplingConfig{Initial: -1},
+	}))
+	assert.NotNil(t, OverrideZapConfig(origin, &ZapConfigOverride{
+		Encoding:         stringPtr("json"),
+		ErrorOutputPaths: []string{"stderr", ""},
+	}))
+
+	// Origin should not be modified
+	assert.Equal(t, NewZapDefaultConfig().Encoding, origin.Encoding)
+}
+
+func TestOverrideZapConfig_WithZeroLevel(t *testing.T) {
+	origin := &zap.Config{}
+	level := zapcore.WarnLevel
+
+	assert.Nil(t, OverrideZapConfig(origin, &ZapConfigOverride{Level: &level}))
+	assert.Equal(t, zapcore.WarnLevel, origin.Level.Level())
+}
+
+func TestOverrideZapConfig_WithFields(t *testing.T) {
+	levelEncoder := zapcore.LevelEncoder(zapcore.CapitalLevelEncoder)
+	timeEncoder := zapcore.TimeEncoder(zapcore.EpochTimeEncoder)
+	durationEncoder := zapcore.DurationEncoder(zapcore.NanosDurationEncoder)
+	callerEncoder := zapcore.CallerEncoder(zapcore.FullCallerEncoder)
+	nameEncoder := zapcore.NameEncoder(func(s string, encoder zapcore.PrimitiveArrayEncoder) {})
+	reflectedEncoder := func(w io.Writer) zapcore.ReflectedEncoder { return json.NewEncoder(w) }
+	level := zapcore.ErrorLevel
+	sampling := &zap.SamplingConfig{Initial: 1, Thereafter: 2}
+
+	tests := []struct {
+		name     string
+		override *ZapConfigOverride
+		expect   func(config *zap.Config) interface{}
+		value    interface{}
+	}{
+		{"level", &ZapConfigOverride{Level: &level},
+			func(c *zap.Config) interface{} { return c.Level.Level() }, zapcore.ErrorLevel},
+		{"development", &ZapConfigOverride{Development: boolPtr(true)},
+			func(c *zap.Config) interface{} { return c.Development }, true},
+		{"disableCaller", &ZapConfigOverride{DisableCaller: boolPtr(true)},
+			func(c *zap.Config) interface{} { return c.DisableCaller }, true},
+		{"disableStacktrace", &ZapConfigOverride{DisableStacktrace: boolPtr(true)},
+			func(c *zap.Config) interface{} { return c.DisableStacktrace }, true},
+		{"sampling", &ZapConfigOverride{Sampling: sampling},
+			func(c *zap.Config) interface{} { return c.Sampling }, sampling},
+		{"encoding", &ZapConfigOverride{Encoding: stringPtr("console")},
+			func(c *zap.Config) interface{} { return c.Encoding }, "console"},
+		{"outputPaths", &ZapConfigOverride{OutputPaths: []string{"stdout", "ut.log"}},
+			func(c *zap.Config) interface{} { return c.OutputPaths }, []string{"stdout", "ut.log"}},
+		{"emptyOutputPaths", &ZapConfigOverride{OutputPaths: []string{}},
+			func(c *zap.Config) interface{} { return c.OutputPaths }, []string{}},
+		{"errorOutputPaths", &ZapConfigOverride{ErrorOutputPaths: []string{"ut.log"}},
+			func(c *zap.Config) interface{} { return c.ErrorOutputPaths }, []string{"ut.log"}},
+		{"initialFields", &ZapConfigOverride{InitialFields: map[string]interface{}{"key": "value"}},
+			func(c *zap.Config) interface{} { return c.InitialFields }, map[string]interface{}{"key": "value"}},
+		{"messageKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{MessageKey: stringPtr("ut-msg")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.MessageKey }, "ut-msg"},
+		{"levelKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{LevelKey: stringPtr("ut-level")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.LevelKey }, "ut-level"},
+		{"timeKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{TimeKey: stringPtr("")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.TimeKey }, ""},
+		{"nameKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{NameKey: stringPtr("ut-name")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.NameKey }, "ut-name"},
+		{"callerKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{CallerKey: stringPtr("ut-caller")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.CallerKey }, "ut-caller"},
+		{"functionKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{FunctionKey: stringPtr("ut-func")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.FunctionKey }, "ut-func"},
+		{"stacktraceKey", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{StacktraceKey: stringPtr("ut-stack")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.StacktraceKey }, "ut-stack"},
+		{"skipLineEnding", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{SkipLineEnding: boolPtr(true)}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.SkipLineEnding }, true},
+		{"lineEnding", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{LineEnding: stringPtr("ut-line")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.LineEnding }, "ut-line"},
+		{"consoleSeparator", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{ConsoleSeparator: stringPtr("|")}},
+			func(c *zap.Config) interface{} { return c.EncoderConfig.ConsoleSeparator }, "|"},
+		{"levelEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{EncodeLevel: &levelEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.EncodeLevel).Pointer() },
+			reflect.ValueOf(levelEncoder).Pointer()},
+		{"timeEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{EncodeTime: &timeEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.EncodeTime).Pointer() },
+			reflect.ValueOf(timeEncoder).Pointer()},
+		{"durationEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{EncodeDuration: &durationEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.EncodeDuration).Pointer() },
+			reflect.ValueOf(durationEncoder).Pointer()},
+		{"callerEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{EncodeCaller: &callerEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.EncodeCaller).Pointer() },
+			reflect.ValueOf(callerEncoder).Pointer()},
+		{"nameEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{EncodeName: &nameEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.EncodeName).Pointer() },
+			reflect.ValueOf(nameEncoder).Pointer()},
+		{"newReflectedEncoder", &ZapConfigOverride{EncoderConfig: &EncoderConfigOverride{NewReflectedEncoder: reflectedEncoder}},
+			func(c *zap.Config) interface{} { return reflect.ValueOf(c.EncoderConfig.NewReflectedEncoder).Pointer() },
+			reflect.ValueOf(reflectedEncoder).Pointer()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := NewZapDefaultConfig()
+			origin.Development = true
+			origin.DisableCaller = true
+
+			assert.Nil(t, OverrideZapConfig(origin, tt.override))
+			assert.Equal(t, tt.value, tt.expect(origin))
+
+			// Fields which were not set should be kept
+			if tt.override.Development == nil {
+				assert.True(t, origin.Development)
+			}
+			if tt.override.DisableCaller == nil {
+				assert.True(t, origin.DisableCaller)
+			}
+			if tt.override.Encoding == nil {
+				assert.Equal(t, "json", origin.Encoding)
+			}
+		})
+	}
+}
+
+func TestOverrideZapConfig_WithYAML(t *testing.T) {
+	override := &ZapConfigOverride{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+level: debug
+development: false
+encoderConfig:
+  timeKey: ""
+  levelEncoder: capital
+  timeEncoder:
+    layout: "2006"
+`), override))
+
+	origin := NewZapDefaultConfig()
+	origin.Development = true
+	assert.Nil(t, OverrideZapConfig(origin, override))
+
+	assert.Equal(t, zapcore.DebugLevel, origin.Level.Level())
+	assert.False(t, origin.Development)
+	assert.Empty(t, origin.EncoderConfig.TimeKey)
+	assert.Equal(t, "msg", origin.EncoderConfig.MessageKey)
+	assert.Equal(t, []string{"stderr"}, origin.OutputPaths)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
 func TestOverrideZapConfig_HappyCase(t *testing.T) {
 	origin := &zap.Config{
 		Level: zap.NewAtomicLevelAt(zapcore.InfoLevel),
@@ -100,7 +270,7 @@ func TestOverrideZapConfig_HappyCase(t *testing.T) {
 		},
 	}
 
-	OverrideZapConfig(origin, override)
+	assert.Nil(t, OverrideZapConfig(origin, NewZapConfigOverride(override)))
 	assert.Equal(t, override.Development, origin.Development)
 	assert.Equal(t, override.DisableCaller, origin.DisableCaller)
 	assert.Equal(t, override.DisableStacktrace, origin.DisableStacktrace)
@@ -129,6 +299,32 @@ func TestOverrideZapConfig_HappyCase(t *testing.T) {
 	assert.Equal(t, override.EncoderConfig.FunctionKey, origin.EncoderConfig.FunctionKey)
 	assert.Equal(t, override.EncoderConfig.StacktraceKey, origin.EncoderConfig.StacktraceKey)
 	assert.Equal(t, override.EncoderConfig.LineEnding, origin.EncoderConfig.LineEnding)
+
+	// Override should not be changed along with zap config
+	converted := NewZapConfigOverride(override)
+	override.Encoding = "console"
+	assert.Equal(t, "json", *converted.Encoding)
+	assert.Nil(t, NewZapConfigOverride(nil))
+}
+
+func TestNewZapConfigOverride_WithZeroFields(t *testing.T) {
+	origin := NewZapDefaultConfig()
+	expected := NewZapDefaultConfig()
+
+	// Zero fields should not overwrite origin, same as legacy OverrideZapConfig
+	assert.Nil(t, OverrideZapConfig(origin, NewZapConfigOverride(&zap.Config{
+		Encoding:      "json",
+		EncoderConfig: zapcore.EncoderConfig{MessageKey: "ut-message"},
+	})))
+	assert.Equal(t, "json", origin.Encoding)
+	assert.Equal(t, "ut-message", origin.EncoderConfig.MessageKey)
+	assert.Equal(t, expected.Level.Level(), origin.Level.Level())
+	assert.Equal(t, expected.OutputPaths, origin.OutputPaths)
+	assert.Equal(t, expected.ErrorOutputPaths, origin.ErrorOutputPaths)
+	assert.Equal(t, expected.Sampling, origin.Sampling)
+	assert.Equal(t, expected.EncoderConfig.LevelKey, origin.EncoderConfig.LevelKey)
+	assert.Equal(t, expected.EncoderConfig.LineEnding, origin.EncoderConfig.LineEnding)
+	assert.NotNil(t, origin.EncoderConfig.EncodeTime)
 }
 
 func TestGetDefaultIfEmptyString_ExpectDefault(t *testing.T) {
@@ -0,0 +1,237 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RequestIdHeaderKey is the HTTP header of request id
+	RequestIdHeaderKey = "X-Request-Id"
+	// TraceparentHeaderKey is the HTTP header of W3C trace context, https://www.w3.org/TR/trace-context/
+	TraceparentHeaderKey = "traceparent"
+	// RequestIdFieldKey is the key of zap field of request id
+	RequestIdFieldKey = "requestId"
+	// TraceIdFieldKey is the key of zap field of trace id in traceparent
+	TraceIdFieldKey = "traceId"
+)
+
+type contextKey int
+
+const (
+	requestIdContextKey contextKey = iota
+	traceparentContextKey
+	zapFieldsContextKey
+	loggerContextKey
+)
+
+var traceparentRegex = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// Traceparent is the parsed traceparent header of W3C trace context.
+type Traceparent struct {
+	// Version of traceparent, 00 is the only version currently
+	Version string
+	// TraceId is 16 bytes trace id in lower case hex
+	TraceId string
+	// ParentId is 8 bytes span id of caller in lower case hex
+	ParentId string
+	// Flags is trace flags in hex, 01 means sampled
+	Flags string
+}
+
+// ParseTraceparent parses traceparent header, error would be returned if header is malformed or ids are all zero.
+func ParseTraceparent(header string) (*Traceparent, error) {
+	tokens := traceparentRegex.FindStringSubmatch(strings.TrimSpace(header))
+	if tokens == nil || tokens[1] == "ff" {
+		return nil, fmt.Errorf("invalid traceparent %q", header)
+	}
+
+	if tokens[2] == strings.Repeat("0", 32) || tokens[3] == strings.Repeat("0", 16) {
+		return nil, fmt.Errorf("invalid traceparent %q, trace id and parent id should not be all zero", header)
+	}
+
+	return &Traceparent{
+		Version:  tokens[1],
+		TraceId:  tokens[2],
+		ParentId: tokens[3],
+		Flags:    tokens[4],
+	}, nil
+}
+
+// String formats traceparent as header value.
+func (t *Traceparent) String() string {
+	return strings.Join([]string{t.Version, t.TraceId, t.ParentId, t.Flags}, "-")
+}
+
+// WithNewParentId returns a copy of traceparent with random parent id, which should be used for outgoing requests.
+func (t *Traceparent) WithNewParentId() *Traceparent {
+	res := *t
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err == nil {
+		res.ParentId = hex.EncodeToString(id)
+	}
+
+	return &res
+}
+
+// ContextWithRequestId returns a copy of ctx with request id.
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey, requestId)
+}
+
+// GetRequestIdFromContext returns request id in ctx, empty string would be returned if absent.
+func GetRequestIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	requestId, _ := ctx.Value(requestIdContextKey).(string)
+	return requestId
+}
+
+// ContextWithTraceparent returns a copy of ctx with traceparent.
+func ContextWithTraceparent(ctx context.Context, traceparent *Traceparent) context.Context {
+	return context.WithValue(ctx, traceparentContextKey, traceparent)
+}
+
+// GetTraceparentFromContext returns traceparent in ctx, nil would be returned if absent.
+func GetTraceparentFromContext(ctx context.Context) *Traceparent {
+	if ctx == nil {
+		return nil
+	}
+
+	traceparent, _ := ctx.Value(traceparentContextKey).(*Traceparent)
+	return traceparent
+}
+
+// ContextWithZapFields returns a copy of ctx with fields appended to fields already in ctx.
+func ContextWithZapFields(ctx context.Context, fields ...zap.Field) context.Context {
+	origin, _ := ctx.Value(zapFieldsContextKey).([]zap.Field)
+
+	res := make([]zap.Field, 0, len(origin)+len(fields))
+	res = append(res, origin...)
+	res = append(res, fields...)
+
+	return context.WithValue(ctx, zapFieldsContextKey, res)
+}
+
+// GetZapFieldsFromContext returns request id, trace id and fields added with ContextWithZapFields in ctx.
+func GetZapFieldsFromContext(ctx context.Context) []zap.Field {
+	res := make([]zap.Field, 0)
+	if ctx == nil {
+		return res
+	}
+
+	if requestId := GetRequestIdFromContext(ctx); len(requestId) > 0 {
+		res = append(res, zap.String(RequestIdFieldKey, requestId))
+	}
+
+	if traceparent := GetTraceparentFromContext(ctx); traceparent != nil {
+		res = append(res, zap.String(TraceIdFieldKey, traceparent.TraceId))
+	}
+
+	fields, _ := ctx.Value(zapFieldsContextKey).([]zap.Field)
+	return append(res, fields...)
+}
+
+// ContextWithLogger returns a copy of ctx with logger.
+func ContextWithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// GetLoggerFromContext returns logger in ctx enriched with fields of GetZapFieldsFromContext.
+// zap.L() would be used if no logger in ctx.
+func GetLoggerFromContext(ctx context.Context) *zap.Logger {
+	logger := zap.L()
+	if ctx == nil {
+		return logger
+	}
+
+	if inCtx, ok := ctx.Value(loggerContextKey).(*zap.Logger); ok && inCtx != nil {
+		logger = inCtx
+	}
+
+	if fields := GetZapFieldsFromContext(ctx); len(fields) > 0 {
+		return logger.With(fields...)
+	}
+
+	return logger
+}
+
+// ContextWithHttpHeader returns a copy of ctx with request id and traceparent in header of incoming request.
+// Request id rejected by ValidateRequestId and malformed traceparent are ignored.
+func ContextWithHttpHeader(ctx context.Context, header http.Header) context.Context {
+	if requestId := header.Get(RequestIdHeaderKey); ValidateRequestId(requestId) == nil {
+		ctx = ContextWithRequestId(ctx, requestId)
+	}
+
+	if traceparent, err := ParseTraceparent(header.Get(TraceparentHeaderKey)); err == nil {
+		ctx = ContextWithTraceparent(ctx, traceparent)
+	}
+
+	return ctx
+}
+
+// InjectHttpHeader sets request id and traceparent in ctx into header of outgoing request.
+// Parent id of traceparent is regenerated as the span of outgoing request.
+func InjectHttpHeader(ctx context.Context, header http.Header) {
+	if requestId := GetRequestIdFromContext(ctx); len(requestId) > 0 {
+		header.Set(RequestIdHeaderKey, requestId)
+	}
+
+	if traceparent := GetTraceparentFromContext(ctx); traceparent != nil {
+		header.Set(TraceparentHeaderKey, traceparent.WithNewParentId().String())
+	}
+}
+
+// NewRequestIdHttpMiddleware returns handler which puts request id and traceparent of incoming request into context.
+//
+// Request id would be generated with GenerateRequestId if absent or invalid, and returned with response header.
+func NewRequestIdHttpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := ContextWithHttpHeader(req.Context(), req.Header)
+
+		requestId := GetRequestIdFromContext(ctx)
+		if len(requestId) < 1 {
+			requestId = GenerateRequestId()
+			ctx = ContextWithRequestId(ctx, requestId)
+		}
+		w.Header().Set(RequestIdHeaderKey, requestId)
+
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// NewPropagationRoundTripper returns http.RoundTripper which injects request id and traceparent in context of
+// outgoing request into its header. http.DefaultTransport would be used if next is nil.
+func NewPropagationRoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &propagationRoundTripper{next: next}
+}
+
+type propagationRoundTripper struct {
+	next http.RoundTripper
+}
+
+// RoundTrip clones request since RoundTripper should not modify request.
+func (p *propagationRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := req.Clone(req.Context())
+	InjectHttpHeader(req.Context(), clone.Header)
+
+	return p.next.RoundTrip(clone)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	res, err := ParseTraceparent(testTraceparent)
+	assert.Nil(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", res.TraceId)
+	assert.Equal(t, "00f067aa0ba902b7", res.ParentId)
+	assert.Equal(t, testTraceparent, res.String())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		_, err = ParseTraceparent(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestTraceparent_WithNewParentId(t *testing.T) {
+	origin, err := ParseTraceparent(testTraceparent)
+	assert.Nil(t, err)
+
+	res := origin.WithNewParentId()
+	assert.Equal(t, origin.TraceId, res.TraceId)
+	assert.Equal(t, origin.Flags, res.Flags)
+	assert.NotEqual(t, origin.ParentId, res.ParentId)
+	assert.Len(t, res.ParentId, 16)
+	assert.Equal(t, "00f067aa0ba902b7", origin.ParentId)
+}
+
+func TestGetZapFieldsFromContext(t *testing.T) {
+	assert.Empty(t, GetZapFieldsFromContext(nil))
+	assert.Empty(t, GetRequestIdFromContext(nil))
+	assert.Nil(t, GetTraceparentFromContext(nil))
+
+	traceparent, _ := ParseTraceparent(testTraceparent)
+	ctx := ContextWithRequestId(context.Background(), "ut-id")
+	ctx = ContextWithTraceparent(ctx, traceparent)
+	ctx = ContextWithZapFields(ctx, zap.String("a", "a"))
+
+	// fields of parent context should not be modified
+	child := ContextWithZapFields(ctx, zap.String("b", "b"))
+
+	assert.Equal(t, []zap.Field{
+		zap.String(RequestIdFieldKey, "ut-id"),
+		zap.String(TraceIdFieldKey, traceparent.TraceId),
+		zap.String("a", "a"),
+	}, GetZapFieldsFromContext(ctx))
+	assert.Len(t, GetZapFieldsFromContext(child), 4)
+}
+
+func TestGetLoggerFromContext(t *testing.T) {
+	assert.Equal(t, zap.L(), GetLoggerFromContext(nil))
+	assert.Equal(t, zap.L(), GetLoggerFromContext(context.Background()))
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := ContextWithLogger(context.Background(), zap.New(core))
+	ctx = ContextWithRequestId(ctx, "ut-id")
+	ctx = ContextWithZapFields(ctx, zap.String("tenant", "ut-tenant"))
+
+	GetLoggerFromContext(ctx).Info("ut-message")
+	assert.Equal(t, map[string]interface{}{
+		RequestIdFieldKey: "ut-id",
+		"tenant":          "ut-tenant",
+	}, logs.AllUntimed()[0].ContextMap())
+}
+
+func TestNewRequestIdHttpMiddleware(t *testing.T) {
+	var ctx context.Context
+	handler := NewRequestIdHttpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx = req.Context()
+	}))
+
+	// With request id and traceparent
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIdHeaderKey, "ut-id")
+	req.Header.Set(TraceparentHeaderKey, testTraceparent)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "ut-id", GetRequestIdFromContext(ctx))
+	assert.Equal(t, testTraceparent, GetTraceparentFromContext(ctx).String())
+	assert.Equal(t, "ut-id", resp.Header().Get(RequestIdHeaderKey))
+
+	// Without request id and with malformed traceparent
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeaderKey, "malformed")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.NotEmpty(t, GetRequestIdFromContext(ctx))
+	assert.Nil(t, GetTraceparentFromContext(ctx))
+	assert.Equal(t, GetRequestIdFromContext(ctx), resp.Header().Get(RequestIdHeaderKey))
+
+	// With invalid request id
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIdHeaderKey, "<script>")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.NotEqual(t, "<script>", GetRequestIdFromContext(ctx))
+	assert.Nil(t, ValidateRequestId(GetRequestIdFromContext(ctx)))
+}
+
+func TestNewPropagationRoundTripper(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+	defer server.Close()
+
+	traceparent, _ := ParseTraceparent(testTraceparent)
+	ctx := ContextWithTraceparent(ContextWithRequestId(context.Background(), "ut-id"), traceparent)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.Nil(t, err)
+
+	client := &http.Client{Transport: NewPropagationRoundTripper(nil)}
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "ut-id", header.Get(RequestIdHeaderKey))
+	outgoing, err := ParseTraceparent(header.Get(TraceparentHeaderKey))
+	assert.Nil(t, err)
+	assert.Equal(t, traceparent.TraceId, outgoing.TraceId)
+	assert.NotEqual(t, traceparent.ParentId, outgoing.ParentId)
+
+	// original request should not be modified
+	assert.Empty(t, req.Header.Get(RequestIdHeaderKey))
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"bytes"
+	"errors"
+	"go.uber.org/zap/zapcore"
+	"strings"
+)
+
+// checkWrapped checks entry with wrapped core, and adds a core which calls write with the CheckedEntry returned by
+// wrapped core if entry is enabled.
+//
+// Cores like tee and sampler decide which cores to write at Check, so that wrapper of them should write into the
+// CheckedEntry instead of calling Write of them directly.
+func checkWrapped(wrapped zapcore.Core, entry zapcore.Entry, checked *zapcore.CheckedEntry,
+	write func(*zapcore.CheckedEntry, zapcore.Entry, []zapcore.Field) error) *zapcore.CheckedEntry {
+	inner := wrapped.Check(entry, nil)
+	if inner == nil {
+		return checked
+	}
+
+	return checked.AddCore(entry, &checkedCore{
+		Core:  wrapped,
+		inner: inner,
+		write: write,
+	})
+}
+
+// checkedCore is added into CheckedEntry by checkWrapped, it is used for a single Write.
+type checkedCore struct {
+	zapcore.Core
+	inner *zapcore.CheckedEntry
+	write func(*zapcore.CheckedEntry, zapcore.Entry, []zapcore.Field) error
+}
+
+// Write calls write of wrapper with CheckedEntry of wrapped core.
+func (c *checkedCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.write(c.inner, entry, fields)
+}
+
+// writeChecked writes entry and fields into cores of checked and returns errors of them.
+//
+// Entry replaces the one of checked, since caller and stack are added by logger after Check.
+func writeChecked(checked *zapcore.CheckedEntry, entry zapcore.Entry, fields []zapcore.Field) error {
+	out := &checkedErrorOutput{}
+	checked.Entry = entry
+	checked.ErrorOutput = out
+	checked.Write(fields...)
+
+	return out.err()
+}
+
+// checkedErrorOutput collects write errors reported by CheckedEntry.
+type checkedErrorOutput struct {
+	bytes.Buffer
+}
+
+func (o *checkedErrorOutput) Sync() error {
+	return nil
+}
+
+// err returns errors in output without time prefix, nil if nothing was reported.
+func (o *checkedErrorOutput) err() error {
+	if o.Len() < 1 {
+		return nil
+	}
+
+	msg := strings.TrimSpace(o.String())
+	if i := strings.Index(msg, "write error: "); i >= 0 {
+		msg = msg[i+len("write error: "):]
+	}
+
+	return errors.New(msg)
+}
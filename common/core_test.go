@@ -0,0 +1,34 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"testing"
+)
+
+func TestCheckWrapped(t *testing.T) {
+	ws := newBlockingWriteSyncer(true)
+	ws.err = errors.New("ut-error")
+	core := NewRedactCore(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), ws,
+		zapcore.InfoLevel), DefaultRedactor)
+
+	// Disabled by wrapped core
+	assert.Nil(t, core.Check(zapcore.Entry{Level: zapcore.DebugLevel}, nil))
+
+	// Errors of wrapped core should be reported
+	checked := core.Check(zapcore.Entry{Level: zapcore.InfoLevel, Message: "ut-message"}, nil)
+	assert.NotNil(t, checked)
+	out := &checkedErrorOutput{}
+	checked.ErrorOutput = out
+	checked.Write()
+
+	assert.Contains(t, ws.String(), "ut-message")
+	assert.EqualError(t, out.err(), "ut-error")
+}
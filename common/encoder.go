@@ -0,0 +1,291 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// EncodingPresetConsoleColor is console encoding with colored capital level, mainly used in local development
+	EncodingPresetConsoleColor = "console-color"
+	// EncodingPresetJSON is JSON encoding with ISO8601 time
+	EncodingPresetJSON = "json"
+	// EncodingPresetLogfmt is logfmt encoding with RFC3339 time
+	EncodingPresetLogfmt = "logfmt"
+	// EncodingPresetECS is JSON encoding with field names of Elastic Common Schema
+	EncodingPresetECS = "ecs"
+	// EncodingPresetGCP is JSON encoding with field names and severities of Google Cloud Logging
+	EncodingPresetGCP = "gcp"
+	// EncodingPresetOTel is JSON encoding with field names of OpenTelemetry log data model
+	EncodingPresetOTel = "otel"
+
+	// ECSEncoding is the name of JSON encoder registered into zap which writes caller as file name and line fields
+	// of Elastic Common Schema, it is used by EncodingPresetECS.
+	ECSEncoding = "ecs-json"
+	// ECSCallerLineKey is the key of caller line written by ECSEncoding
+	ECSCallerLineKey = "log.origin.file.line"
+)
+
+var (
+	encodingPresetLock sync.RWMutex
+	encodingPresets    = map[string]*EncodingPreset{
+		EncodingPresetConsoleColor: {
+			Encoding: "console",
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:          "ts",
+				LevelKey:         "level",
+				NameKey:          "logger",
+				CallerKey:        "caller",
+				FunctionKey:      zapcore.OmitKey,
+				MessageKey:       "msg",
+				StacktraceKey:    "stacktrace",
+				LineEnding:       zapcore.DefaultLineEnding,
+				EncodeLevel:      zapcore.CapitalColorLevelEncoder,
+				EncodeTime:       zapcore.ISO8601TimeEncoder,
+				EncodeDuration:   zapcore.StringDurationEncoder,
+				EncodeCaller:     zapcore.ShortCallerEncoder,
+				ConsoleSeparator: "\t",
+			},
+		},
+		EncodingPresetJSON: {
+			Encoding: "json",
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:        "ts",
+				LevelKey:       "level",
+				NameKey:        "logger",
+				CallerKey:      "caller",
+				FunctionKey:    zapcore.OmitKey,
+				MessageKey:     "msg",
+				StacktraceKey:  "stacktrace",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    zapcore.LowercaseLevelEncoder,
+				EncodeTime:     zapcore.ISO8601TimeEncoder,
+				EncodeDuration: zapcore.StringDurationEncoder,
+				EncodeCaller:   zapcore.ShortCallerEncoder,
+			},
+		},
+		EncodingPresetLogfmt: {
+			Encoding: LogfmtEncoding,
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:        "ts",
+				LevelKey:       "level",
+				NameKey:        "logger",
+				CallerKey:      "caller",
+				FunctionKey:    zapcore.OmitKey,
+				MessageKey:     "msg",
+				StacktraceKey:  "stacktrace",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    zapcore.LowercaseLevelEncoder,
+				EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+				EncodeDuration: zapcore.StringDurationEncoder,
+				EncodeCaller:   zapcore.ShortCallerEncoder,
+			},
+		},
+		// https://www.elastic.co/guide/en/ecs/current/ecs-log.html
+		EncodingPresetECS: {
+			Encoding: ECSEncoding,
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:        "@timestamp",
+				LevelKey:       "log.level",
+				NameKey:        "log.logger",
+				CallerKey:      "log.origin.file.name",
+				FunctionKey:    "log.origin.function",
+				MessageKey:     "message",
+				StacktraceKey:  "error.stack_trace",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    zapcore.LowercaseLevelEncoder,
+				EncodeTime:     zapcore.ISO8601TimeEncoder,
+				EncodeDuration: zapcore.NanosDurationEncoder,
+				EncodeCaller:   zapcore.ShortCallerEncoder,
+			},
+			InitialFields: map[string]interface{}{
+				"ecs.version": "1.6.0",
+			},
+		},
+		// https://cloud.google.com/logging/docs/structured-logging
+		EncodingPresetGCP: {
+			Encoding: "json",
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:        "time",
+				LevelKey:       "severity",
+				NameKey:        "logger",
+				CallerKey:      "caller",
+				FunctionKey:    zapcore.OmitKey,
+				MessageKey:     "message",
+				StacktraceKey:  "stack_trace",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    GCPSeverityEncoder,
+				EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+				EncodeDuration: zapcore.StringDurationEncoder,
+				EncodeCaller:   zapcore.ShortCallerEncoder,
+			},
+		},
+		// https://opentelemetry.io/docs/reference/specification/logs/data-model/
+		EncodingPresetOTel: {
+			Encoding: "json",
+			EncoderConfig: zapcore.EncoderConfig{
+				TimeKey:        "Timestamp",
+				LevelKey:       "SeverityText",
+				NameKey:        "InstrumentationScope",
+				CallerKey:      "code.filepath",
+				FunctionKey:    "code.function",
+				MessageKey:     "Body",
+				StacktraceKey:  "exception.stacktrace",
+				LineEnding:     zapcore.DefaultLineEnding,
+				EncodeLevel:    OTelSeverityEncoder,
+				EncodeTime:     zapcore.EpochNanosTimeEncoder,
+				EncodeDuration: zapcore.NanosDurationEncoder,
+				EncodeCaller:   zapcore.ShortCallerEncoder,
+			},
+		},
+	}
+)
+
+func init() {
+	// ECS encoder registered by others would be kept
+	zap.RegisterEncoder(ECSEncoding, func(config zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return NewECSEncoder(config), nil
+	})
+}
+
+// EncodingPreset is a named combination of encoding and encoder config, which could be applied with
+// encodingPreset in ZapConfigOverride.
+type EncodingPreset struct {
+	// Encoding of zap.Config
+	Encoding string
+	// EncoderConfig of zap.Config
+	EncoderConfig zapcore.EncoderConfig
+	// InitialFields which would be added to zap.Config if absent
+	InitialFields map[string]interface{}
+}
+
+// RegisterEncodingPreset registers preset with name, error would be returned if name was registered already.
+func RegisterEncodingPreset(name string, preset *EncodingPreset) error {
+	if len(name) < 1 || preset == nil {
+		return errors.New("empty name or nil encoding preset")
+	}
+
+	encodingPresetLock.Lock()
+	defer encodingPresetLock.Unlock()
+
+	if _, ok := encodingPresets[name]; ok {
+		return fmt.Errorf("encoding preset %q already registered", name)
+	}
+
+	encodingPresets[name] = preset
+	return nil
+}
+
+// GetEncodingPreset returns preset registered with name.
+func GetEncodingPreset(name string) (*EncodingPreset, bool) {
+	encodingPresetLock.RLock()
+	defer encodingPresetLock.RUnlock()
+
+	preset, ok := encodingPresets[name]
+	return preset, ok
+}
+
+// ListEncodingPresets returns names of registered presets in sorted order.
+func ListEncodingPresets() []string {
+	encodingPresetLock.RLock()
+	defer encodingPresetLock.RUnlock()
+
+	res := make([]string, 0, len(encodingPresets))
+	for name := range encodingPresets {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// GCPSeverityEncoder encodes level as severity of Google Cloud Logging.
+func GCPSeverityEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch level {
+	case zapcore.DebugLevel:
+		enc.AppendString("DEBUG")
+	case zapcore.InfoLevel:
+		enc.AppendString("INFO")
+	case zapcore.WarnLevel:
+		enc.AppendString("WARNING")
+	case zapcore.ErrorLevel:
+		enc.AppendString("ERROR")
+	case zapcore.DPanicLevel:
+		enc.AppendString("CRITICAL")
+	case zapcore.PanicLevel:
+		enc.AppendString("ALERT")
+	case zapcore.FatalLevel:
+		enc.AppendString("EMERGENCY")
+	default:
+		enc.AppendString("DEFAULT")
+	}
+}
+
+// OTelSeverityEncoder encodes level as severity text of OpenTelemetry log data model.
+func OTelSeverityEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch level {
+	case zapcore.DebugLevel:
+		enc.AppendString("DEBUG")
+	case zapcore.InfoLevel:
+		enc.AppendString("INFO")
+	case zapcore.WarnLevel:
+		enc.AppendString("WARN")
+	case zapcore.ErrorLevel:
+		enc.AppendString("ERROR")
+	default:
+		enc.AppendString("FATAL")
+	}
+}
+
+// NewECSEncoder returns JSON encoder which writes file of caller with CallerKey and line of caller with
+// ECSCallerLineKey, as log.origin.file.name and log.origin.file.line of Elastic Common Schema.
+//
+// EncodeCaller is ignored since file and line are written as separated fields.
+func NewECSEncoder(config zapcore.EncoderConfig) zapcore.Encoder {
+	callerKey := config.CallerKey
+	// function is still written by JSON encoder
+	config.CallerKey = zapcore.OmitKey
+
+	return &ecsEncoder{
+		Encoder:   zapcore.NewJSONEncoder(config),
+		callerKey: callerKey,
+	}
+}
+
+type ecsEncoder struct {
+	zapcore.Encoder
+	callerKey string
+}
+
+func (enc *ecsEncoder) Clone() zapcore.Encoder {
+	return &ecsEncoder{
+		Encoder:   enc.Encoder.Clone(),
+		callerKey: enc.callerKey,
+	}
+}
+
+func (enc *ecsEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if ent.Caller.Defined && len(enc.callerKey) > 0 {
+		// trimmed path is package/file:line
+		file := ent.Caller.TrimmedPath()
+		if i := strings.LastIndexByte(file, ':'); i > 0 {
+			file = file[:i]
+		}
+
+		fields = append(fields[:len(fields):len(fields)],
+			zap.String(enc.callerKey, file), zap.Int(ECSCallerLineKey, ent.Caller.Line))
+	}
+
+	return enc.Encoder.EncodeEntry(ent, fields)
+}
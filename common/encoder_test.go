@@ -0,0 +1,185 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+// logWithPreset builds logger with preset and returns JSON decoded entry of an error log.
+func logWithPreset(t *testing.T, preset string) map[string]interface{} {
+	config := &zap.Config{}
+	assert.Nil(t, OverrideZapConfig(config, &ZapConfigOverride{EncodingPreset: &preset}))
+
+	buf := &bytes.Buffer{}
+	enc, err := newEncoderForTest(config)
+	assert.Nil(t, err)
+
+	fields := make([]zap.Field, 0)
+	for k, v := range config.InitialFields {
+		fields = append(fields, zap.Any(k, v))
+	}
+	logger := zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel), zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel)).
+		Named("ut-logger").With(fields...)
+	logger.Error("ut-message")
+
+	res := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &res))
+	return res
+}
+
+func newEncoderForTest(config *zap.Config) (zapcore.Encoder, error) {
+	switch config.Encoding {
+	case "console":
+		return zapcore.NewConsoleEncoder(config.EncoderConfig), nil
+	case LogfmtEncoding:
+		return NewLogfmtEncoder(config.EncoderConfig), nil
+	case ECSEncoding:
+		return NewECSEncoder(config.EncoderConfig), nil
+	default:
+		return zapcore.NewJSONEncoder(config.EncoderConfig), nil
+	}
+}
+
+func TestOverrideZapConfig_WithEncodingPreset(t *testing.T) {
+	// ECS
+	res := logWithPreset(t, EncodingPresetECS)
+	assert.Equal(t, "ut-message", res["message"])
+	assert.Equal(t, "error", res["log.level"])
+	assert.Equal(t, "ut-logger", res["log.logger"])
+	assert.Equal(t, "1.6.0", res["ecs.version"])
+	assert.Contains(t, res, "@timestamp")
+	assert.Equal(t, "common/encoder_test.go", res["log.origin.file.name"])
+	assert.IsType(t, float64(0), res[ECSCallerLineKey])
+	assert.Contains(t, res["log.origin.function"], "logWithPreset")
+	assert.Contains(t, res, "error.stack_trace")
+
+	// GCP
+	res = logWithPreset(t, EncodingPresetGCP)
+	assert.Equal(t, "ut-message", res["message"])
+	assert.Equal(t, "ERROR", res["severity"])
+	assert.Contains(t, res, "time")
+	assert.Contains(t, res, "stack_trace")
+
+	// OTel
+	res = logWithPreset(t, EncodingPresetOTel)
+	assert.Equal(t, "ut-message", res["Body"])
+	assert.Equal(t, "ERROR", res["SeverityText"])
+	assert.IsType(t, float64(0), res["Timestamp"])
+
+	// JSON
+	res = logWithPreset(t, EncodingPresetJSON)
+	assert.Equal(t, "ut-message", res["msg"])
+	assert.Equal(t, "error", res["level"])
+}
+
+func TestOverrideZapConfig_WithEncodingPresetAndFields(t *testing.T) {
+	override := &ZapConfigOverride{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+encodingPreset: ecs
+initialFields:
+  service: ut-service
+  ecs.version: 8.0.0
+encoderConfig:
+  messageKey: msg
+`), override))
+
+	config := NewZapDefaultConfig()
+	assert.Nil(t, OverrideZapConfig(config, override))
+	assert.Equal(t, ECSEncoding, config.Encoding)
+	assert.Equal(t, "msg", config.EncoderConfig.MessageKey)
+	assert.Equal(t, "log.level", config.EncoderConfig.LevelKey)
+	assert.Equal(t, map[string]interface{}{"service": "ut-service", "ecs.version": "8.0.0"}, config.InitialFields)
+
+	// Fields of preset should be added without modifying fields of override
+	override.InitialFields = map[string]interface{}{"service": "ut-service"}
+	assert.Nil(t, OverrideZapConfig(config, override))
+	assert.Equal(t, "1.6.0", config.InitialFields["ecs.version"])
+	assert.Len(t, override.InitialFields, 1)
+
+	// ECS encoder should be registered into zap
+	_, err := config.Build()
+	assert.Nil(t, err)
+
+	// Console with color
+	preset := EncodingPresetConsoleColor
+	assert.Nil(t, OverrideZapConfig(config, &ZapConfigOverride{EncodingPreset: &preset}))
+	assert.Equal(t, "console", config.Encoding)
+	_, err = config.Build()
+	assert.Nil(t, err)
+
+	// Logfmt should be registered into zap
+	preset = EncodingPresetLogfmt
+	assert.Nil(t, OverrideZapConfig(config, &ZapConfigOverride{EncodingPreset: &preset}))
+	assert.Equal(t, LogfmtEncoding, config.Encoding)
+	_, err = config.Build()
+	assert.Nil(t, err)
+}
+
+func TestOverrideZapConfig_WithUnknownEncodingPreset(t *testing.T) {
+	preset := "unknown"
+	config := NewZapDefaultConfig()
+	err := OverrideZapConfig(config, &ZapConfigOverride{EncodingPreset: &preset, Encoding: stringPtr("console")})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), EncodingPresetECS)
+	assert.Equal(t, "json", config.Encoding)
+}
+
+func TestRegisterEncodingPreset(t *testing.T) {
+	defer func() {
+		encodingPresetLock.Lock()
+		delete(encodingPresets, "ut-preset")
+		encodingPresetLock.Unlock()
+	}()
+
+	assert.NotNil(t, RegisterEncodingPreset("", &EncodingPreset{}))
+	assert.NotNil(t, RegisterEncodingPreset("ut-preset", nil))
+	assert.NotNil(t, RegisterEncodingPreset(EncodingPresetJSON, &EncodingPreset{}))
+
+	assert.Nil(t, RegisterEncodingPreset("ut-preset", &EncodingPreset{Encoding: "console"}))
+	preset, ok := GetEncodingPreset("ut-preset")
+	assert.True(t, ok)
+	assert.Equal(t, "console", preset.Encoding)
+	assert.Contains(t, ListEncodingPresets(), "ut-preset")
+}
+
+func TestSeverityEncoders(t *testing.T) {
+	gcp := map[zapcore.Level]string{
+		zapcore.DebugLevel:  "DEBUG",
+		zapcore.InfoLevel:   "INFO",
+		zapcore.WarnLevel:   "WARNING",
+		zapcore.ErrorLevel:  "ERROR",
+		zapcore.DPanicLevel: "CRITICAL",
+		zapcore.PanicLevel:  "ALERT",
+		zapcore.FatalLevel:  "EMERGENCY",
+		zapcore.Level(100):  "DEFAULT",
+	}
+	for level, expected := range gcp {
+		arr := &logfmtPrimitiveEncoder{}
+		GCPSeverityEncoder(level, arr)
+		assert.Equal(t, []interface{}{expected}, arr.elems)
+	}
+
+	otel := map[zapcore.Level]string{
+		zapcore.DebugLevel: "DEBUG",
+		zapcore.InfoLevel:  "INFO",
+		zapcore.WarnLevel:  "WARN",
+		zapcore.ErrorLevel: "ERROR",
+		zapcore.FatalLevel: "FATAL",
+	}
+	for level, expected := range otel {
+		arr := &logfmtPrimitiveEncoder{}
+		OTelSeverityEncoder(level, arr)
+		assert.Equal(t, []interface{}{expected}, arr.elems)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"encoding"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	objectMarshalerType = reflect.TypeOf((*zapcore.ObjectMarshaler)(nil)).Elem()
+	arrayMarshalerType  = reflect.TypeOf((*zapcore.ArrayMarshaler)(nil)).Elem()
+	errorType           = reflect.TypeOf((*error)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// convertToZapFields converts struct or map into typed zap fields with sensitive data masked by r.
+//
+// Fields of struct are in declaration order and entries of map are sorted by key. Values implementing
+// zapcore.ObjectMarshaler are inlined. Empty slice would be returned for other types.
+func convertToZapFields(src interface{}, r *Redactor) []zap.Field {
+	fields := make([]zap.Field, 0)
+	if src == nil {
+		return fields
+	}
+
+	v := reflect.ValueOf(src)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fields
+		}
+
+		if m, ok := asInterface(v, objectMarshalerType); ok {
+			return append(fields, zap.Inline(m.(zapcore.ObjectMarshaler)))
+		}
+		v = v.Elem()
+	}
+
+	if m, ok := asInterface(v, objectMarshalerType); ok {
+		return append(fields, zap.Inline(m.(zapcore.ObjectMarshaler)))
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		// copy into addressable value so that marshalers with pointer receiver could be found
+		if !v.CanAddr() {
+			addressable := reflect.New(v.Type()).Elem()
+			addressable.Set(v)
+			v = addressable
+		}
+		return toZapFields(fields, v, r, 0)
+	case reflect.Map:
+		return append(fields, (&mapMarshaler{value: v, redactor: r}).fields()...)
+	}
+
+	return fields
+}
+
+// toZapFields appends fields of struct v into fields in declaration order, fields of embedded structs are promoted
+// as encoding/json does.
+func toZapFields(fields []zap.Field, v reflect.Value, r *Redactor, depth int) []zap.Field {
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		name, omitEmpty, ok := jsonFieldName(structField)
+		if !ok {
+			continue
+		}
+
+		value := v.Field(i)
+		if omitEmpty && isEmptyValue(value) {
+			continue
+		}
+
+		if hasRkTagOption(structField, RedactTag) || r.RedactKey(name) {
+			fields = append(fields, zap.String(name, r.mask))
+			continue
+		}
+
+		// promote fields of embedded struct without json name
+		if structField.Anonymous && len(strings.Split(structField.Tag.Get("json"), ",")[0]) < 1 {
+			for value.Kind() == reflect.Ptr && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct && !implementsAny(value, objectMarshalerType, jsonMarshalerType) {
+				fields = toZapFields(fields, value, r, depth+1)
+				continue
+			}
+		}
+
+		fields = append(fields, toZapField(name, value, r, depth+1))
+	}
+
+	return fields
+}
+
+// toZapField converts v into typed zap.Field, structs and maps are converted into zap.Object, slices and arrays are
+// converted into zap.Array.
+func toZapField(key string, v reflect.Value, r *Redactor, depth int) zap.Field {
+	if depth > maxRedactDepth {
+		return zap.Skip()
+	}
+
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return zap.Reflect(key, nil)
+		}
+
+		if m, ok := asInterface(v, objectMarshalerType); ok {
+			return zap.Object(key, m.(zapcore.ObjectMarshaler))
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return zap.Reflect(key, nil)
+	}
+
+	if m, ok := asInterface(v, objectMarshalerType); ok {
+		return zap.Object(key, m.(zapcore.ObjectMarshaler))
+	}
+
+	if m, ok := asInterface(v, arrayMarshalerType); ok {
+		return zap.Array(key, m.(zapcore.ArrayMarshaler))
+	}
+
+	switch v.Type() {
+	case timeType:
+		return zap.Time(key, v.Interface().(time.Time))
+	case durationType:
+		return zap.Duration(key, time.Duration(v.Int()))
+	}
+
+	if err, ok := asInterface(v, errorType); ok {
+		return zap.String(key, r.RedactString(err.(error).Error()))
+	}
+
+	// values with custom JSON format are kept as they are
+	if m, ok := asInterface(v, jsonMarshalerType); ok {
+		return zap.Reflect(key, m)
+	}
+
+	if m, ok := asInterface(v, textMarshalerType); ok {
+		text, err := m.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return zap.Reflect(key, m)
+		}
+		return zap.String(key, r.RedactString(string(text)))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return zap.Bool(key, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return zap.Int64(key, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return zap.Uint64(key, v.Uint())
+	case reflect.Float32:
+		return zap.Float32(key, float32(v.Float()))
+	case reflect.Float64:
+		return zap.Float64(key, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		return zap.Complex128(key, v.Complex())
+	case reflect.String:
+		return zap.String(key, r.RedactString(v.String()))
+	case reflect.Struct:
+		return zap.Object(key, &structMarshaler{value: v, redactor: r, depth: depth})
+	case reflect.Map:
+		if v.IsNil() {
+			return zap.Reflect(key, nil)
+		}
+		return zap.Object(key, &mapMarshaler{value: v, redactor: r, depth: depth})
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return zap.Reflect(key, nil)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return zap.Binary(key, bytesOf(v))
+		}
+		return zap.Array(key, &sliceMarshaler{value: v, redactor: r, depth: depth})
+	}
+
+	// chan, func and unsafe pointer are not supported by encoding/json either
+	return zap.Skip()
+}
+
+// asInterface returns v or pointer of v as interface if any of them implements typ.
+func asInterface(v reflect.Value, typ reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(typ) && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(typ) && v.Addr().CanInterface() {
+		return v.Addr().Interface(), true
+	}
+
+	return nil, false
+}
+
+func implementsAny(v reflect.Value, types ...reflect.Type) bool {
+	for _, typ := range types {
+		if _, ok := asInterface(v, typ); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+
+	res := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(res), v)
+	return res
+}
+
+// structMarshaler marshals exported fields of struct in declaration order.
+type structMarshaler struct {
+	value    reflect.Value
+	redactor *Redactor
+	depth    int
+}
+
+// MarshalLogObject adds converted fields into enc.
+func (m *structMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, field := range toZapFields(make([]zap.Field, 0), m.value, m.redactor, m.depth) {
+		field.AddTo(enc)
+	}
+
+	return nil
+}
+
+// mapMarshaler marshals entries of map in sorted order of keys.
+type mapMarshaler struct {
+	value    reflect.Value
+	redactor *Redactor
+	depth    int
+}
+
+// MarshalLogObject adds converted entries into enc.
+func (m *mapMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, field := range m.fields() {
+		field.AddTo(enc)
+	}
+
+	return nil
+}
+
+// fields converts entries into zap fields sorted by key, keys are formatted as encoding/json does.
+func (m *mapMarshaler) fields() []zap.Field {
+	keys := make([]string, 0, m.value.Len())
+	values := make(map[string]reflect.Value, m.value.Len())
+
+	iter := m.value.MapRange()
+	for iter.Next() {
+		key := fmt.Sprint(iter.Key().Interface())
+		if text, ok := asInterface(iter.Key(), textMarshalerType); ok && iter.Key().Kind() != reflect.String {
+			if b, err := text.(encoding.TextMarshaler).MarshalText(); err == nil {
+				key = string(b)
+			}
+		}
+
+		keys = append(keys, key)
+		values[key] = iter.Value()
+	}
+	sort.Strings(keys)
+
+	res := make([]zap.Field, 0, len(keys))
+	for _, key := range keys {
+		if m.redactor.RedactKey(key) {
+			res = append(res, zap.String(key, m.redactor.mask))
+			continue
+		}
+		res = append(res, toZapField(key, values[key], m.redactor, m.depth+1))
+	}
+
+	return res
+}
+
+// sliceMarshaler marshals elements of slice or array in order.
+type sliceMarshaler struct {
+	value    reflect.Value
+	redactor *Redactor
+	depth    int
+}
+
+// MarshalLogArray appends converted elements into enc.
+func (m *sliceMarshaler) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for i := 0; i < m.value.Len(); i++ {
+		if err := appendZapField(enc, toZapField("", m.value.Index(i), m.redactor, m.depth+1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendZapField appends value of field converted by toZapField into enc.
+func appendZapField(enc zapcore.ArrayEncoder, field zap.Field) error {
+	switch field.Type {
+	case zapcore.BoolType:
+		enc.AppendBool(field.Integer == 1)
+	case zapcore.Int64Type:
+		enc.AppendInt64(field.Integer)
+	case zapcore.Uint64Type:
+		enc.AppendUint64(uint64(field.Integer))
+	case zapcore.Float32Type:
+		enc.AppendFloat32(math.Float32frombits(uint32(field.Integer)))
+	case zapcore.Float64Type:
+		enc.AppendFloat64(math.Float64frombits(uint64(field.Integer)))
+	case zapcore.Complex128Type:
+		enc.AppendComplex128(field.Interface.(complex128))
+	case zapcore.StringType:
+		enc.AppendString(field.String)
+	case zapcore.DurationType:
+		enc.AppendDuration(time.Duration(field.Integer))
+	case zapcore.TimeType, zapcore.TimeFullType:
+		enc.AppendTime(timeOfField(field))
+	case zapcore.BinaryType:
+		return enc.AppendReflected(field.Interface)
+	case zapcore.ObjectMarshalerType:
+		return enc.AppendObject(field.Interface.(zapcore.ObjectMarshaler))
+	case zapcore.ArrayMarshalerType:
+		return enc.AppendArray(field.Interface.(zapcore.ArrayMarshaler))
+	default:
+		return enc.AppendReflected(field.Interface)
+	}
+
+	return nil
+}
+
+// timeOfField restores time.Time from field created by zap.Time.
+func timeOfField(field zap.Field) time.Time {
+	if field.Type == zapcore.TimeFullType {
+		return field.Interface.(time.Time)
+	}
+
+	if loc, ok := field.Interface.(*time.Location); ok {
+		return time.Unix(0, field.Integer).In(loc)
+	}
+
+	return time.Unix(0, field.Integer)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeZapFields encodes fields into JSON without entry metadata.
+func encodeZapFields(t *testing.T, fields []zap.Field) string {
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		EncodeTime:     zapcore.RFC3339TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	})
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, fields)
+	assert.Nil(t, err)
+
+	return strings.TrimSpace(buf.String())
+}
+
+type fieldsMarshaler struct {
+	Name string
+}
+
+func (m *fieldsMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("marshaled", m.Name)
+	return nil
+}
+
+type fieldsInner struct {
+	ID   int64    `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+type fieldsEmbedded struct {
+	Promoted bool `json:"promoted"`
+}
+
+type fieldsStruct struct {
+	fieldsEmbedded
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	Unsigned  uint8   `json:"unsigned"`
+	Ratio     float64 `json:"ratio"`
+	Small     float32 `json:"small"`
+	Omitted   string  `json:"omitted,omitempty"`
+	Ignored   string  `json:"-"`
+	NoTag     string
+	Time      time.Time              `json:"time"`
+	Duration  time.Duration          `json:"duration"`
+	Inner     fieldsInner            `json:"inner"`
+	InnerPtr  *fieldsInner           `json:"innerPtr"`
+	Nil       *fieldsInner           `json:"nil"`
+	Map       map[string]interface{} `json:"map"`
+	List      []fieldsInner          `json:"list"`
+	Bytes     []byte                 `json:"bytes"`
+	Marshaler fieldsMarshaler        `json:"marshaler"`
+	Err       error                  `json:"err"`
+	IP        net.IP                 `json:"ip"`
+	Func      func()                 `json:"func"`
+	Secret    string                 `json:"secret"`
+	internal  string
+}
+
+func TestConvertStructToZapFields_WithTypes(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fieldsStruct{
+		fieldsEmbedded: fieldsEmbedded{Promoted: true},
+		Name:           "name",
+		Count:          1,
+		Unsigned:       2,
+		Ratio:          0.5,
+		Small:          0.1,
+		Ignored:        "ignored",
+		NoTag:          "noTag",
+		Time:           now,
+		Duration:       time.Second,
+		Inner:          fieldsInner{ID: 3, Tags: []string{"a", "b"}},
+		InnerPtr:       &fieldsInner{ID: 4},
+		Map:            map[string]interface{}{"z": 1, "a": []int{1, 2}, "token": "t"},
+		List:           []fieldsInner{{ID: 5}},
+		Bytes:          []byte("bytes"),
+		Marshaler:      fieldsMarshaler{Name: "m"},
+		Err:            errors.New("Bearer abc"),
+		IP:             net.ParseIP("127.0.0.1"),
+		Secret:         "secret",
+		internal:       "internal",
+	}
+
+	res := ConvertStructToZapFields(src)
+
+	// typed fields in declaration order
+	keys := make([]string, 0)
+	for _, field := range res {
+		if field.Type != zapcore.SkipType {
+			keys = append(keys, field.Key)
+		}
+	}
+	assert.Equal(t, []string{
+		"promoted", "name", "count", "unsigned", "ratio", "small", "NoTag", "time", "duration", "inner", "innerPtr",
+		"nil", "map", "list", "bytes", "marshaler", "err", "ip", "secret",
+	}, keys)
+	assert.Equal(t, zapcore.Int64Type, res[2].Type)
+	assert.Equal(t, zapcore.TimeType, res[7].Type)
+	assert.Equal(t, zapcore.ObjectMarshalerType, res[9].Type)
+
+	assert.Equal(t, `{"promoted":true,"name":"name","count":1,"unsigned":2,"ratio":0.5,"small":0.1,"NoTag":"noTag",`+
+		`"time":"2021-01-02T03:04:05Z","duration":"1s","inner":{"id":3,"tags":["a","b"]},"innerPtr":{"id":4,"tags":null},`+
+		`"nil":null,"map":{"a":[1,2],"token":"******","z":1},"list":[{"id":5,"tags":null}],"bytes":"Ynl0ZXM=",`+
+		`"marshaler":{"marshaled":"m"},"err":"Bearer ******","ip":"127.0.0.1","secret":"******"}`,
+		encodeZapFields(t, res))
+
+	// same result with pointer
+	assert.Equal(t, encodeZapFields(t, res), encodeZapFields(t, ConvertStructToZapFields(&src)))
+}
+
+func TestConvertStructToZapFields_WithMap(t *testing.T) {
+	res := ConvertStructToZapFields(map[int]interface{}{2: "b", 1: nil})
+	assert.Equal(t, `{"1":null,"2":"b"}`, encodeZapFields(t, res))
+}
+
+func TestConvertStructToZapFields_WithObjectMarshaler(t *testing.T) {
+	res := ConvertStructToZapFields(&fieldsMarshaler{Name: "m"})
+	assert.Equal(t, `{"marshaled":"m"}`, encodeZapFields(t, res))
+}
+
+func TestConvertStructToZapFields_WithUnsupportedType(t *testing.T) {
+	assert.Empty(t, ConvertStructToZapFields("str"))
+	assert.Empty(t, ConvertStructToZapFields((*fieldsStruct)(nil)))
+}
+
+type fieldsCycle struct {
+	Name string       `json:"name"`
+	Next *fieldsCycle `json:"next"`
+}
+
+func TestConvertStructToZapFields_WithCycle(t *testing.T) {
+	src := &fieldsCycle{Name: "a"}
+	src.Next = src
+
+	assert.Contains(t, encodeZapFields(t, ConvertStructToZapFields(src)), `{"name":"a","next":{"name":"a","next":`)
+}
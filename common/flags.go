@@ -20,8 +20,10 @@ var (
 )
 
 const (
-	BootConfigPathFlagKey = "rkboot"
-	BootConfigOverrideKey = "rkset"
+	BootConfigPathFlagKey     = "rkboot"
+	BootConfigOverrideKey     = "rkset"
+	BootConfigOverrideFileKey = "rkset-file"
+	BootConfigOverrideJSONKey = "rkset-json"
 )
 
 // pflag.FlagSet which contains rkboot and rkset as key.
@@ -55,11 +57,32 @@ const (
 // 2: Using [index] to access arrays in YAML file.
 // 3: Using equal sign(=) to distinguish key and value.
 // 4: Using dot(.) to access map in YAML file.
+//
+// rkset could be specified multiple times, values would be applied in order and the latter one wins.
+//
+// ./your_compiled_binary --rkboot example-boot.yaml --rkset "gin[0].port=2008" --rkset "gin[0].port=2009"
+//
+// Usage of rkset-file:
+//
+// Same as rkset, but value of each key is the path of a file whose content would be used as value.
+// It is useful for multi-line values or values which contains comma and dot.
+// example:
+//
+// ./your_compiled_binary --rkboot example-boot.yaml --rkset-file "gin[0].tls.cert=server.pem"
+//
+// Usage of rkset-json:
+//
+// Same as rkset, but value of each key is a JSON document, which could be an object, array or scalar.
+// example:
+//
+// ./your_compiled_binary --rkboot example-boot.yaml --rkset-json 'gin[0].commonService={"enabled":false}'
 func init() {
 	// GlobalFlags will continue with error
 	GlobalFlags = pflag.NewFlagSet("rk", pflag.ContinueOnError)
 	GlobalFlags.String(BootConfigPathFlagKey, "", "set config file path")
-	GlobalFlags.String(BootConfigOverrideKey, "", "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	GlobalFlags.StringArray(BootConfigOverrideKey, []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	GlobalFlags.StringArray(BootConfigOverrideFileKey, []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	GlobalFlags.StringArray(BootConfigOverrideJSONKey, []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
 	GlobalFlags.Parse(os.Args[1:])
 }
 
@@ -94,20 +117,42 @@ func GetBootConfigPath(configFilePath string) string {
 }
 
 // GetBootConfigOverrides this function will read user provided config content overrides and construct it into a map.
+//
+// Values from --rkset-json, --rkset and --rkset-file are merged in order, the latter one wins if a key is set more
+// than once, which is the same as HELM does. Each flag could be repeated, values of the same flag are applied in the
+// order they were provided.
 func GetBootConfigOverrides() map[interface{}]interface{} {
-	bootConfigOverrides, err := GlobalFlags.GetString(BootConfigOverrideKey)
+	res := make(map[interface{}]interface{})
+
+	parsers := []struct {
+		key   string
+		parse func(string, map[interface{}]interface{}) error
+	}{
+		{key: BootConfigOverrideJSONKey, parse: ParseBootConfigOverridesJSONInto},
+		{key: BootConfigOverrideKey, parse: ParseBootConfigOverridesInto},
+		{key: BootConfigOverrideFileKey, parse: ParseBootConfigOverridesFileInto},
+	}
 
-	if err != nil {
-		ShutdownWithError(err)
+	for _, p := range parsers {
+		for _, bootConfigOverrides := range getBootConfigOverrideValues(p.key) {
+			if err := p.parse(bootConfigOverrides, res); err != nil {
+				ShutdownWithError(err)
+			}
+		}
 	}
 
-	res, err := ParseBootConfigOverrides(bootConfigOverrides)
+	return res
+}
+
+// getBootConfigOverrideValues returns set lines of provided key in the order they should be applied.
+func getBootConfigOverrideValues(key string) []string {
+	values, err := GlobalFlags.GetStringArray(key)
 
 	if err != nil {
 		ShutdownWithError(err)
 	}
 
-	return res
+	return values
 }
 
 // GetBootConfigOriginal read config file content and unmarshal into map.
@@ -145,8 +190,16 @@ func UnmarshalBootConfig(configFilePath string, config interface{}) {
 	// 2: read command line flags and override original config map with flags
 	OverrideMap(configMap, GetBootConfigOverrides())
 
-	// 3: decode config map into boot config struct
-	if err := mapstructure.Decode(configMap, config); err != nil {
+	// 3: decode config map into boot config struct, strings are decoded into types like zapcore.Level with UnmarshalText
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.TextUnmarshallerHookFunc(),
+		Result:     config,
+	})
+	if err != nil {
+		ShutdownWithError(err)
+	}
+
+	if err := decoder.Decode(configMap); err != nil {
 		ShutdownWithError(err)
 	}
 }
@@ -6,12 +6,19 @@
 package rkcommon
 
 import (
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
 	"io/ioutil"
 	"path"
 	"testing"
 )
 
+// resetFlag clears values of string array flags, since Set would append to them.
+func resetFlag(key string) {
+	GlobalFlags.Lookup(key).Value.(pflag.SliceValue).Replace([]string{})
+}
+
 func TestGetBootConfigPath_WithFlags(t *testing.T) {
 	filePath := path.Join(t.TempDir(), "ut-temp.yaml")
 	assert.Nil(t, ioutil.WriteFile(filePath, []byte(""), 0777))
@@ -70,20 +77,73 @@ func TestGetBootConfigOverrides_ExpectPanic(t *testing.T) {
 	}()
 
 	GlobalFlags.Set("rkset", "should panic")
-	defer GlobalFlags.Set("rkset", "")
+	defer resetFlag("rkset")
 
 	GetBootConfigOverrides()
 }
 
 func TestGetBootConfigOverrides_HappyCase(t *testing.T) {
 	GlobalFlags.Set("rkset", "key=value,slice[0]=value")
-	defer GlobalFlags.Set("rkset", "")
+	defer resetFlag("rkset")
 
 	res := GetBootConfigOverrides()
 	assert.Equal(t, "value", res["key"])
 	assert.Equal(t, "value", res["slice"].([]interface{})[0])
 }
 
+func TestGetBootConfigOverrides_WithFileAndJSON(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "ut-temp.pem")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte("value,from.file"), 0777))
+
+	GlobalFlags.Set("rkset-json", `key={"a":1,"b":2},json=[1,2]`)
+	defer resetFlag("rkset-json")
+	GlobalFlags.Set("rkset", "key.a=3")
+	defer resetFlag("rkset")
+	GlobalFlags.Set("rkset-file", "key.b="+filePath)
+	defer resetFlag("rkset-file")
+
+	res := GetBootConfigOverrides()
+	assert.Equal(t, map[interface{}]interface{}{"a": 3, "b": "value,from.file"}, res["key"])
+	assert.Equal(t, []interface{}{1, 2}, res["json"])
+}
+
+func TestGetBootConfigOverrides_WithInvalidJSON(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			// expect panic to be called with non nil error
+			assert.True(t, true)
+		} else {
+			// this should never be called in case of a bug
+			assert.True(t, false)
+		}
+	}()
+
+	GlobalFlags.Set("rkset-json", `key={`)
+	defer resetFlag("rkset-json")
+
+	GetBootConfigOverrides()
+}
+
+func TestGetBootConfigOverrides_WithRepeatedFlags(t *testing.T) {
+	GlobalFlags.Set("rkset", "key=value1,slice[0]=value,other=value")
+	GlobalFlags.Set("rkset", "key=value2,slice[1]=value")
+	defer resetFlag("rkset")
+
+	res := GetBootConfigOverrides()
+	assert.Equal(t, "value2", res["key"])
+	assert.Equal(t, "value", res["other"])
+	assert.Equal(t, []interface{}{"value", "value"}, res["slice"])
+}
+
+func TestGetBootConfigOverrides_WithRepeatedArgs(t *testing.T) {
+	defer resetFlag("rkset")
+	assert.Nil(t, GlobalFlags.Parse([]string{"--rkset", `a=1,b=c\,d`, "--rkset", "a=2"}))
+
+	res := GetBootConfigOverrides()
+	assert.Equal(t, 2, res["a"])
+	assert.Equal(t, "c,d", res["b"])
+}
+
 func TestGetBootConfigOriginal_WithNonExistFile(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -129,7 +189,7 @@ key: value
 func TestUnmarshalBootConfig_HappyCase(t *testing.T) {
 	// Set flags in order to override
 	GlobalFlags.Set("rkset", "key=value2")
-	defer GlobalFlags.Set("rkset", "")
+	defer resetFlag("rkset")
 
 	// Write original file to local file system
 	filePath := path.Join(t.TempDir(), "ut-temp.yaml")
@@ -148,6 +208,37 @@ key: value
 	assert.Equal(t, "value2", config.Key)
 }
 
+func TestUnmarshalBootConfig_WithZapLoggerConfig(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "ut-temp.yaml")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte(`
+---
+logger:
+  name: ut-logger
+  zap:
+    level: warn
+    encoderConfig:
+      levelEncoder: capital
+  rotation:
+    maxSize: 10
+    filenamePattern: app-%Y%m%d.log
+  rateLimit:
+    summaryInterval: 1m
+`), 0777))
+
+	type MyStruct struct {
+		Logger *ZapLoggerConfig `yaml:"logger"`
+	}
+	config := &MyStruct{}
+	UnmarshalBootConfig(filePath, config)
+
+	assert.Equal(t, "ut-logger", config.Logger.Name)
+	assert.Equal(t, zapcore.WarnLevel, *config.Logger.Zap.Level)
+	assert.NotNil(t, config.Logger.Zap.EncoderConfig.EncodeLevel)
+	assert.Equal(t, 10, config.Logger.Rotation.MaxSize)
+	assert.Equal(t, "app-%Y%m%d.log", config.Logger.Rotation.FilenamePattern)
+	assert.Equal(t, "1m", config.Logger.RateLimit.SummaryInterval)
+}
+
 func TestUnmarshalBootConfig_WithInvalidConfigType(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
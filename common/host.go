@@ -0,0 +1,181 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPodInfoDir is the directory where downward API volume of pod metadata is conventionally mounted,
+	// files named podName, podNamespace and nodeName are read from it
+	DefaultPodInfoDir = "/etc/podinfo"
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	fqdnLookupTimeout           = time.Second
+	kubernetesServiceHostEnvKey = "KUBERNETES_SERVICE_HOST"
+)
+
+var (
+	hostInfoOnce sync.Once
+	hostInfo     *HostInfo
+
+	containerIdRegex          = regexp.MustCompile(`([0-9a-f]{64})`)
+	containerIdMountinfoRegex = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+	podNameEnvKeys            = []string{"POD_NAME", "K8S_POD_NAME", "MY_POD_NAME"}
+	podNamespaceEnvKeys       = []string{"POD_NAMESPACE", "K8S_POD_NAMESPACE", "MY_POD_NAMESPACE"}
+	nodeNameEnvKeys           = []string{"NODE_NAME", "K8S_NODE_NAME", "MY_NODE_NAME"}
+)
+
+// HostInfo is the identity of current process, host, container and Kubernetes pod.
+type HostInfo struct {
+	// Hostname returned by GetLocalHostname
+	Hostname string `json:"hostname"`
+	// FQDN is the canonical name of hostname, hostname would be used if lookup failed
+	FQDN string `json:"fqdn"`
+	// IP is the preferred address returned by GetLocalIP
+	IP string `json:"ip"`
+	// IPs are all addresses returned by ListLocalIPs with default options
+	IPs []string `json:"ips"`
+	// PID is the process id
+	PID int `json:"pid"`
+	// Locale returned by GetLocale
+	Locale string `json:"locale"`
+	// ContainerId parsed from /proc/self/cgroup or /proc/self/mountinfo, empty if not in container
+	ContainerId string `json:"containerId"`
+	// PodName from POD_NAME env or podName file in DefaultPodInfoDir, hostname is used in Kubernetes if absent
+	PodName string `json:"podName"`
+	// PodNamespace from POD_NAMESPACE env, podNamespace file in DefaultPodInfoDir or namespace of service account
+	PodNamespace string `json:"podNamespace"`
+	// NodeName from NODE_NAME env or nodeName file in DefaultPodInfoDir
+	NodeName string `json:"nodeName"`
+}
+
+// hostInfoSource abstracts system calls, so that gathering could be tested.
+type hostInfoSource struct {
+	getenv      func(string) string
+	readFile    func(string) ([]byte, error)
+	lookupCNAME func(string) (string, error)
+}
+
+// GetHostInfo returns HostInfo which is gathered at the first call and cached.
+func GetHostInfo() *HostInfo {
+	hostInfoOnce.Do(func() {
+		hostInfo = gatherHostInfo(&hostInfoSource{
+			getenv:   os.Getenv,
+			readFile: ioutil.ReadFile,
+			lookupCNAME: func(host string) (string, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), fqdnLookupTimeout)
+				defer cancel()
+				return net.DefaultResolver.LookupCNAME(ctx, host)
+			},
+		})
+		hostInfo.IP = GetLocalIP()
+		if ips, err := ListLocalIPs(nil); err == nil {
+			for i := range ips {
+				hostInfo.IPs = append(hostInfo.IPs, ips[i].String())
+			}
+		}
+	})
+
+	return hostInfo
+}
+
+// gatherHostInfo gathers fields except IP and IPs from source.
+func gatherHostInfo(src *hostInfoSource) *HostInfo {
+	res := &HostInfo{
+		Hostname: GetLocalHostname(),
+		PID:      os.Getpid(),
+		Locale:   GetLocale(),
+		IPs:      make([]string, 0),
+	}
+
+	res.FQDN = res.Hostname
+	if len(res.Hostname) > 0 {
+		if cname, err := src.lookupCNAME(res.Hostname); err == nil && len(strings.TrimSuffix(cname, ".")) > 0 {
+			res.FQDN = strings.TrimSuffix(cname, ".")
+		}
+	}
+
+	if cgroup, err := src.readFile("/proc/self/cgroup"); err == nil {
+		res.ContainerId = parseContainerId(string(cgroup), containerIdRegex)
+	}
+	if len(res.ContainerId) < 1 {
+		if mountinfo, err := src.readFile("/proc/self/mountinfo"); err == nil {
+			res.ContainerId = parseContainerId(string(mountinfo), containerIdMountinfoRegex)
+		}
+	}
+
+	res.PodName = lookupPodInfo(src, podNameEnvKeys, DefaultPodInfoDir+"/podName")
+	res.PodNamespace = lookupPodInfo(src, podNamespaceEnvKeys, DefaultPodInfoDir+"/podNamespace", serviceAccountNamespaceFile)
+	res.NodeName = lookupPodInfo(src, nodeNameEnvKeys, DefaultPodInfoDir+"/nodeName")
+
+	// hostname of pod is the pod name by default
+	if len(res.PodName) < 1 && len(src.getenv(kubernetesServiceHostEnvKey)) > 0 {
+		res.PodName = res.Hostname
+	}
+
+	return res
+}
+
+// parseContainerId returns container id in the first matched line, lines of the same container carry the same id.
+// If the line contains multiple ids, the last one is returned, since the innermost cgroup comes last in path.
+func parseContainerId(content string, regex *regexp.Regexp) string {
+	for _, line := range strings.Split(content, "\n") {
+		if matches := regex.FindAllStringSubmatch(line, -1); len(matches) > 0 {
+			return matches[len(matches)-1][1]
+		}
+	}
+
+	return ""
+}
+
+// lookupPodInfo returns the first non-empty value of environment variables and files.
+func lookupPodInfo(src *hostInfoSource, envKeys []string, files ...string) string {
+	for _, key := range envKeys {
+		if value := strings.TrimSpace(src.getenv(key)); len(value) > 0 {
+			return value
+		}
+	}
+
+	for _, file := range files {
+		if content, err := src.readFile(file); err == nil && len(strings.TrimSpace(string(content))) > 0 {
+			return strings.TrimSpace(string(content))
+		}
+	}
+
+	return ""
+}
+
+// ZapFields returns non-empty fields of hostname, ip, pid, container and pod for log enrichment.
+func (h *HostInfo) ZapFields() []zap.Field {
+	res := []zap.Field{
+		zap.String("hostname", h.Hostname),
+		zap.String("ip", h.IP),
+		zap.Int("pid", h.PID),
+	}
+
+	for _, pair := range [][2]string{
+		{"containerId", h.ContainerId},
+		{"podName", h.PodName},
+		{"podNamespace", h.PodNamespace},
+		{"nodeName", h.NodeName},
+	} {
+		if len(pair[1]) > 0 {
+			res = append(res, zap.String(pair[0], pair[1]))
+		}
+	}
+
+	return res
+}
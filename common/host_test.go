@@ -0,0 +1,110 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testContainerId = "3e7c6b8a1d2f4e5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a"
+
+func newTestHostInfoSource(env map[string]string, files map[string]string) *hostInfoSource {
+	return &hostInfoSource{
+		getenv: func(key string) string {
+			return env[key]
+		},
+		readFile: func(name string) ([]byte, error) {
+			if content, ok := files[name]; ok {
+				return []byte(content), nil
+			}
+			return nil, os.ErrNotExist
+		},
+		lookupCNAME: func(host string) (string, error) {
+			return host + ".ut.local.", nil
+		},
+	}
+}
+
+func TestGatherHostInfo_InKubernetes(t *testing.T) {
+	res := gatherHostInfo(newTestHostInfoSource(map[string]string{
+		"POD_NAMESPACE":           "ut-ns",
+		"NODE_NAME":               " ut-node ",
+		"KUBERNETES_SERVICE_HOST": "10.96.0.1",
+	}, map[string]string{
+		"/proc/self/cgroup":         "0::/kubepods.slice/kubepods-burstable-pod1a2b_3c4d.slice/cri-containerd-" + testContainerId + ".scope\n",
+		serviceAccountNamespaceFile: "other-ns",
+	}))
+
+	assert.Equal(t, GetLocalHostname(), res.Hostname)
+	assert.Equal(t, GetLocalHostname()+".ut.local", res.FQDN)
+	assert.Equal(t, os.Getpid(), res.PID)
+	assert.Equal(t, testContainerId, res.ContainerId)
+	assert.Equal(t, GetLocalHostname(), res.PodName)
+	assert.Equal(t, "ut-ns", res.PodNamespace)
+	assert.Equal(t, "ut-node", res.NodeName)
+}
+
+func TestGatherHostInfo_WithFiles(t *testing.T) {
+	src := newTestHostInfoSource(map[string]string{}, map[string]string{
+		"/proc/self/cgroup":            "0::/\n",
+		"/proc/self/mountinfo":         "1 2 0:3 /var/lib/docker/containers/" + testContainerId + "/hostname /etc/hostname rw\n",
+		DefaultPodInfoDir + "/podName": "ut-pod\n",
+		serviceAccountNamespaceFile:    "ut-ns",
+	})
+	src.lookupCNAME = func(string) (string, error) {
+		return "", errors.New("ut-error")
+	}
+	res := gatherHostInfo(src)
+
+	assert.Equal(t, res.Hostname, res.FQDN)
+	assert.Equal(t, testContainerId, res.ContainerId)
+	assert.Equal(t, "ut-pod", res.PodName)
+	assert.Equal(t, "ut-ns", res.PodNamespace)
+	assert.Empty(t, res.NodeName)
+}
+
+func TestGatherHostInfo_OutOfContainer(t *testing.T) {
+	res := gatherHostInfo(newTestHostInfoSource(map[string]string{}, map[string]string{
+		"/proc/self/cgroup": "12:pids:/user.slice/user-1000.slice\n",
+	}))
+
+	assert.Empty(t, res.ContainerId)
+	assert.Empty(t, res.PodName)
+	assert.Empty(t, res.PodNamespace)
+}
+
+func TestParseContainerId(t *testing.T) {
+	assert.Equal(t, testContainerId, parseContainerId("12:pids:/docker/"+testContainerId, containerIdRegex))
+	assert.Equal(t, testContainerId, parseContainerId("1:name=systemd:/docker-"+testContainerId+".scope", containerIdRegex))
+	assert.Empty(t, parseContainerId("", containerIdRegex))
+
+	// the last id in the first matched line
+	outer := strings.Repeat("a", 64)
+	assert.Equal(t, testContainerId, parseContainerId("0::/\n1:cpu:/kubepods/"+outer+"/"+testContainerId+"\n2:pids:/docker/"+outer,
+		containerIdRegex))
+}
+
+func TestGetHostInfo(t *testing.T) {
+	res := GetHostInfo()
+	assert.Equal(t, res, GetHostInfo())
+	assert.Equal(t, GetLocalIP(), res.IP)
+	assert.NotNil(t, res.IPs)
+}
+
+func TestHostInfo_ZapFields(t *testing.T) {
+	info := &HostInfo{Hostname: "ut-host", IP: "10.0.0.1", PID: 1, PodName: "ut-pod"}
+	assert.Equal(t, []zap.Field{
+		zap.String("hostname", "ut-host"),
+		zap.String("ip", "10.0.0.1"),
+		zap.Int("pid", 1),
+		zap.String("podName", "ut-pod"),
+	}, info.ZapFields())
+}
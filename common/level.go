@@ -0,0 +1,271 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	rkerror "github.com/rookie-ninja/rk-common/error"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLevelRegistry is the registry used by NewZapLoggerFromConfig for named loggers.
+var DefaultLevelRegistry = NewLevelRegistry()
+
+// LoggerLevel is a snapshot of level of a named logger.
+type LoggerLevel struct {
+	// Name of logger
+	Name string `json:"name" yaml:"name"`
+	// Level of logger
+	Level string `json:"level" yaml:"level"`
+	// RevertLevel is the level which would be restored at RevertAt, empty if level was changed permanently
+	RevertLevel string `json:"revertLevel,omitempty" yaml:"revertLevel,omitempty"`
+	// RevertAt is the time when level would be restored, nil if level was changed permanently
+	RevertAt *time.Time `json:"revertAt,omitempty" yaml:"revertAt,omitempty"`
+}
+
+// SetLevelRequest is the request body of LevelRegistry.ServeHTTP.
+type SetLevelRequest struct {
+	// Name of logger, glob pattern like "rk-*" is supported
+	Name string `json:"name" yaml:"name"`
+	// Level to set, like debug, info, warn and error
+	Level string `json:"level" yaml:"level"`
+	// TTL of level, like 10m, level would be reverted after TTL, empty means change level permanently
+	TTL string `json:"ttl" yaml:"ttl"`
+}
+
+// LevelRegistry keeps atomic levels of named loggers, so that levels of live processes could be changed.
+//
+// Loggers registered with the same name share the same zap.AtomicLevel.
+type LevelRegistry struct {
+	lock    sync.Mutex
+	entries map[string]*levelEntry
+}
+
+type levelEntry struct {
+	level       zap.AtomicLevel
+	revertLevel zapcore.Level
+	revertAt    time.Time
+	timer       *time.Timer
+}
+
+// NewLevelRegistry returns an empty level registry.
+func NewLevelRegistry() *LevelRegistry {
+	return &LevelRegistry{
+		entries: make(map[string]*levelEntry),
+	}
+}
+
+// Register registers level with name and returns the level which should be used by logger.
+//
+// If name was registered already, the registered level would be returned with level applied and pending revert
+// canceled, so that loggers with the same name share the same level which follows the latest configuration.
+func (r *LevelRegistry) Register(name string, level zap.AtomicLevel) zap.AtomicLevel {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if entry, ok := r.entries[name]; ok {
+		entry.stopTimer()
+		entry.level.SetLevel(level.Level())
+		return entry.level
+	}
+
+	r.entries[name] = &levelEntry{
+		level: level,
+	}
+
+	return level
+}
+
+// Unregister removes logger with name from registry and cancels pending revert.
+func (r *LevelRegistry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if entry, ok := r.entries[name]; ok {
+		entry.stopTimer()
+		delete(r.entries, name)
+	}
+}
+
+// List returns levels of all registered loggers sorted by name.
+func (r *LevelRegistry) List() []*LoggerLevel {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	res := make([]*LoggerLevel, 0, len(r.entries))
+	for name := range r.entries {
+		res = append(res, r.entries[name].snapshot(name))
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res
+}
+
+// SetLevel sets level of loggers whose name matches pattern, pattern follows the syntax of path.Match.
+//
+// If ttl is positive, level would be reverted after ttl. Setting level again before revert extends the ttl while
+// keeping the level to revert to. If ttl is zero, level is changed permanently and pending revert is canceled.
+//
+// Levels of matched loggers are returned sorted by name.
+func (r *LevelRegistry) SetLevel(pattern string, level zapcore.Level, ttl time.Duration) ([]*LoggerLevel, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid logger name pattern %q: %v", pattern, err)
+	}
+
+	if ttl < 0 {
+		return nil, fmt.Errorf("negative ttl %s not allowed", ttl)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	res := make([]*LoggerLevel, 0)
+	for name := range r.entries {
+		if matched, _ := path.Match(pattern, name); !matched {
+			continue
+		}
+
+		entry := r.entries[name]
+		if ttl > 0 {
+			if entry.timer == nil {
+				entry.revertLevel = entry.level.Level()
+			}
+			entry.stopTimer()
+			entry.revertAt = time.Now().Add(ttl)
+			entry.timer = r.revertAfter(entry, ttl)
+		} else {
+			entry.stopTimer()
+		}
+		entry.level.SetLevel(level)
+
+		res = append(res, entry.snapshot(name))
+	}
+
+	if len(res) < 1 {
+		return nil, fmt.Errorf("no logger matched with %q", pattern)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+// ServeHTTP lists levels of all loggers with GET, sets level of loggers with PUT.
+//
+// Request body of PUT is SetLevelRequest in JSON format, like {"name": "rk-*", "level": "debug", "ttl": "10m"}.
+// Levels of matched loggers are returned.
+//
+// Handler does not authenticate requests, it must be served behind an auth middleware like
+// NewBasicAuthHttpMiddleware or on an internal port, otherwise anyone could turn on debug logs.
+func (r *LevelRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch req.Method {
+	case http.MethodGet:
+		writeLevelResp(w, http.StatusOK, r.List())
+	case http.MethodPut:
+		setReq := &SetLevelRequest{}
+		if err := json.NewDecoder(req.Body).Decode(setReq); err != nil {
+			writeLevelResp(w, http.StatusBadRequest, rkerror.New(
+				rkerror.WithHttpCode(http.StatusBadRequest),
+				rkerror.WithMessage("invalid request body"),
+				rkerror.WithDetails(err)))
+			return
+		}
+
+		level := zapcore.InfoLevel
+		if err := level.UnmarshalText([]byte(setReq.Level)); err != nil || len(setReq.Level) < 1 {
+			writeLevelResp(w, http.StatusBadRequest, rkerror.New(
+				rkerror.WithHttpCode(http.StatusBadRequest),
+				rkerror.WithMessage(fmt.Sprintf("invalid level %q", setReq.Level))))
+			return
+		}
+
+		var ttl time.Duration
+		if len(setReq.TTL) > 0 {
+			var err error
+			if ttl, err = time.ParseDuration(setReq.TTL); err != nil {
+				writeLevelResp(w, http.StatusBadRequest, rkerror.New(
+					rkerror.WithHttpCode(http.StatusBadRequest),
+					rkerror.WithMessage(fmt.Sprintf("invalid ttl %q", setReq.TTL)),
+					rkerror.WithDetails(err)))
+				return
+			}
+		}
+
+		res, err := r.SetLevel(setReq.Name, level, ttl)
+		if err != nil {
+			writeLevelResp(w, http.StatusBadRequest, rkerror.New(
+				rkerror.WithHttpCode(http.StatusBadRequest),
+				rkerror.WithMessage(err.Error())))
+			return
+		}
+
+		writeLevelResp(w, http.StatusOK, res)
+	default:
+		writeLevelResp(w, http.StatusMethodNotAllowed, rkerror.New(
+			rkerror.WithHttpCode(http.StatusMethodNotAllowed),
+			rkerror.WithDetails(errors.New("only GET and PUT are supported"))))
+	}
+}
+
+// revertAfter reverts level of entry after ttl, unless the timer was replaced or stopped.
+func (r *LevelRegistry) revertAfter(entry *levelEntry, ttl time.Duration) *time.Timer {
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		if entry.timer != timer {
+			return
+		}
+
+		entry.level.SetLevel(entry.revertLevel)
+		entry.timer = nil
+	})
+
+	return timer
+}
+
+func (e *levelEntry) stopTimer() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+}
+
+func (e *levelEntry) snapshot(name string) *LoggerLevel {
+	res := &LoggerLevel{
+		Name:  name,
+		Level: e.level.Level().String(),
+	}
+
+	if e.timer != nil {
+		revertAt := e.revertAt
+		res.RevertLevel = e.revertLevel.String()
+		res.RevertAt = &revertAt
+	}
+
+	return res
+}
+
+func writeLevelResp(w http.ResponseWriter, code int, body interface{}) {
+	w.WriteHeader(code)
+	w.Write(ConvertStructToBytes(body))
+}
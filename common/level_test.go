@@ -0,0 +1,140 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevelRegistry_Register(t *testing.T) {
+	registry := NewLevelRegistry()
+
+	level := registry.Register("ut-logger", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+	shared := registry.Register("ut-logger", zap.NewAtomicLevelAt(zapcore.ErrorLevel))
+	assert.Equal(t, level, shared)
+	assert.Equal(t, zapcore.ErrorLevel, shared.Level())
+
+	// Pending revert is canceled by registration
+	_, err := registry.SetLevel("ut-logger", zapcore.DebugLevel, time.Hour)
+	assert.Nil(t, err)
+	registry.Register("ut-logger", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+
+	registry.Register("ut-another", zap.NewAtomicLevelAt(zapcore.WarnLevel))
+	assert.Equal(t, []*LoggerLevel{
+		{Name: "ut-another", Level: "warn"},
+		{Name: "ut-logger", Level: "info"},
+	}, registry.List())
+
+	registry.Unregister("ut-another")
+	assert.Len(t, registry.List(), 1)
+}
+
+func TestLevelRegistry_SetLevel(t *testing.T) {
+	registry := NewLevelRegistry()
+	one := registry.Register("ut-one", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+	two := registry.Register("ut-two", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+	other := registry.Register("other", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+
+	// With glob
+	res, err := registry.SetLevel("ut-*", zapcore.DebugLevel, 0)
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, zapcore.DebugLevel, one.Level())
+	assert.Equal(t, zapcore.DebugLevel, two.Level())
+	assert.Equal(t, zapcore.InfoLevel, other.Level())
+
+	// With exact name
+	_, err = registry.SetLevel("other", zapcore.ErrorLevel, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, zapcore.ErrorLevel, other.Level())
+
+	// With no matched logger
+	_, err = registry.SetLevel("non-exist", zapcore.ErrorLevel, 0)
+	assert.NotNil(t, err)
+
+	// With invalid pattern
+	_, err = registry.SetLevel("[", zapcore.ErrorLevel, 0)
+	assert.NotNil(t, err)
+
+	// With negative ttl
+	_, err = registry.SetLevel("other", zapcore.ErrorLevel, -time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestLevelRegistry_SetLevelWithTTL(t *testing.T) {
+	registry := NewLevelRegistry()
+	level := registry.Register("ut-logger", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+
+	res, err := registry.SetLevel("ut-logger", zapcore.DebugLevel, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "info", res[0].RevertLevel)
+	assert.NotNil(t, res[0].RevertAt)
+
+	// Revert level should be kept while setting level again
+	res, err = registry.SetLevel("ut-logger", zapcore.WarnLevel, 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "info", res[0].RevertLevel)
+	assert.Equal(t, zapcore.WarnLevel, level.Level())
+
+	assert.Eventually(t, func() bool {
+		return level.Level() == zapcore.InfoLevel
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, registry.List()[0].RevertAt)
+
+	// Permanent change should cancel revert
+	registry.SetLevel("ut-logger", zapcore.DebugLevel, 20*time.Millisecond)
+	registry.SetLevel("ut-logger", zapcore.ErrorLevel, 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, zapcore.ErrorLevel, level.Level())
+}
+
+func TestLevelRegistry_ServeHTTP(t *testing.T) {
+	registry := NewLevelRegistry()
+	level := registry.Register("ut-logger", zap.NewAtomicLevelAt(zapcore.InfoLevel))
+
+	// List
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name":"ut-logger","level":"info"}]`, w.Body.String())
+
+	// Set
+	w = httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/",
+		strings.NewReader(`{"name":"ut-*","level":"debug","ttl":"1h"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, zapcore.DebugLevel, level.Level())
+	res := make([]*LoggerLevel, 0)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "info", res[0].RevertLevel)
+
+	// With invalid requests
+	for _, body := range []string{
+		`{`,
+		`{"name":"ut-logger","level":"unknown"}`,
+		`{"name":"ut-logger"}`,
+		`{"name":"ut-logger","level":"debug","ttl":"x"}`,
+		`{"name":"non-exist","level":"debug"}`,
+	} {
+		w = httptest.NewRecorder()
+		registry.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), `"code":400`)
+	}
+
+	// With unsupported method
+	w = httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
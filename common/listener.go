@@ -0,0 +1,264 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ListenFdsEnvKey is the environment variable of number of sockets passed by systemd socket activation
+	ListenFdsEnvKey = "LISTEN_FDS"
+	// ListenPidEnvKey is the environment variable of pid which sockets are passed to by systemd
+	ListenPidEnvKey = "LISTEN_PID"
+	// ListenFdNamesEnvKey is the environment variable of colon separated names of sockets passed by systemd
+	ListenFdNamesEnvKey = "LISTEN_FDNAMES"
+
+	maxFreePortAttempts = 1024
+)
+
+var (
+	freePortLock   sync.Mutex
+	allocatedPorts = make(map[int]bool)
+
+	// listenFdsStart is the first file descriptor passed by systemd, SD_LISTEN_FDS_START in sd-daemon
+	listenFdsStart uintptr = 3
+	listenFdsOnce  sync.Once
+	listenFiles    []*os.File
+	listenFdNames  []string
+	listenFdsLock  sync.Mutex
+	listenFdsUsed  = make(map[int]bool)
+)
+
+// GetFreePort returns a TCP port which is free on all interfaces, see GetFreePorts.
+func GetFreePort() (int, error) {
+	ports, err := GetFreePorts(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return ports[0], nil
+}
+
+// GetFreePorts returns n distinct TCP ports which are free on all interfaces.
+//
+// Ports are never returned twice in the same process, so that concurrent tests would not race for the same port.
+// Ports are released before returning, another process may still bind them, listen on port 0 with NewListener if
+// the port must be guaranteed.
+func GetFreePorts(n int) ([]int, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of free ports %d, should be positive", n)
+	}
+
+	freePortLock.Lock()
+	defer freePortLock.Unlock()
+
+	res := make([]int, 0, n)
+	// keep listeners open until all ports were found, so that OS would not return the same port twice
+	listeners := make([]net.Listener, 0, n)
+	defer func() {
+		for i := range listeners {
+			listeners[i].Close()
+		}
+	}()
+
+	for attempt := 0; len(res) < n; attempt++ {
+		if attempt >= maxFreePortAttempts {
+			return nil, fmt.Errorf("failed to find %d free ports after %d attempts", n, maxFreePortAttempts)
+		}
+
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+
+		port := listener.Addr().(*net.TCPAddr).Port
+		if allocatedPorts[port] {
+			continue
+		}
+
+		res = append(res, port)
+	}
+
+	for _, port := range res {
+		allocatedPorts[port] = true
+	}
+
+	return res, nil
+}
+
+// ParseHostPort parses address like host:port, [::1]:port, ::1 and scheme://user@host:port/path into host and port.
+//
+// Default port of scheme registered with RegisterScheme would be used if port is absent, otherwise port is 0.
+func ParseHostPort(addr string) (string, int, error) {
+	rest := strings.TrimSpace(addr)
+	scheme := ""
+	if i := strings.Index(rest, "://"); i >= 0 {
+		scheme = strings.ToLower(rest[:i])
+		rest = rest[i+3:]
+	}
+
+	// strip path, query and user info
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+
+	host, portStr := rest, ""
+	switch {
+	case strings.HasPrefix(rest, "["):
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return "", 0, fmt.Errorf("missing ']' in address %q", addr)
+		}
+		host = rest[1:end]
+		if tail := rest[end+1:]; len(tail) > 0 {
+			if !strings.HasPrefix(tail, ":") {
+				return "", 0, fmt.Errorf("invalid address %q", addr)
+			}
+			portStr = tail[1:]
+		}
+	case strings.Count(rest, ":") == 1:
+		host, portStr = rest[:strings.Index(rest, ":")], rest[strings.Index(rest, ":")+1:]
+	case strings.Count(rest, ":") > 1:
+		// bare IPv6 without port
+		if net.ParseIP(rest) == nil {
+			return "", 0, fmt.Errorf("invalid address %q", addr)
+		}
+	}
+
+	if len(portStr) < 1 {
+		if s, ok := GetScheme(scheme); ok {
+			return host, s.DefaultPort, nil
+		}
+		return host, 0, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q in address %q", portStr, addr)
+	}
+
+	return host, port, nil
+}
+
+// NewListener creates listener with address, supported formats are:
+//
+// 1: unix:///path/to/socket, stale socket file would be removed.
+// 2: tcp://host:port, tcp4://host:port, tcp6://host:port or host:port without scheme.
+// 3: systemd:// or systemd://name, the first or named socket passed by systemd socket activation with LISTEN_FDS,
+// systemd://0 selects socket by index.
+func NewListener(addr string) (net.Listener, error) {
+	scheme, rest := "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, rest = strings.ToLower(addr[:i]), addr[i+3:]
+	}
+
+	switch scheme {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(scheme, rest)
+	case "unix":
+		if len(rest) < 1 {
+			return nil, fmt.Errorf("missing path of unix socket in %q", addr)
+		}
+		removeStaleUnixSocket(rest)
+		return net.Listen("unix", rest)
+	case "systemd":
+		return newSystemdListener(rest)
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %q of listener address %q", scheme, addr)
+}
+
+// removeStaleUnixSocket removes socket file left by previous process, which no one is listening on.
+func removeStaleUnixSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+
+	os.Remove(path)
+}
+
+// loadListenFds reads sockets passed by systemd once, since file descriptors could only be owned once.
+func loadListenFds() {
+	listenFdsOnce.Do(func() {
+		if pid, err := strconv.Atoi(os.Getenv(ListenPidEnvKey)); err != nil || pid != os.Getpid() {
+			return
+		}
+
+		count, err := strconv.Atoi(os.Getenv(ListenFdsEnvKey))
+		if err != nil || count < 1 {
+			return
+		}
+
+		names := strings.Split(os.Getenv(ListenFdNamesEnvKey), ":")
+		for i := 0; i < count; i++ {
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+			listenFiles = append(listenFiles, os.NewFile(listenFdsStart+uintptr(i), name))
+			listenFdNames = append(listenFdNames, name)
+		}
+	})
+}
+
+// newSystemdListener creates listener with socket passed by systemd selected by name or index.
+func newSystemdListener(selector string) (net.Listener, error) {
+	loadListenFds()
+	if len(listenFiles) < 1 {
+		return nil, errors.New("no socket passed by systemd, LISTEN_PID or LISTEN_FDS is missing or mismatched")
+	}
+
+	index := -1
+	if len(selector) < 1 {
+		index = 0
+	} else if i, err := strconv.Atoi(selector); err == nil {
+		index = i
+	} else {
+		for i := range listenFdNames {
+			if listenFdNames[i] == selector {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 || index >= len(listenFiles) {
+		return nil, fmt.Errorf("socket %q not found in sockets passed by systemd", selector)
+	}
+
+	listenFdsLock.Lock()
+	defer listenFdsLock.Unlock()
+	if listenFdsUsed[index] {
+		return nil, fmt.Errorf("socket %q passed by systemd was already used", selector)
+	}
+
+	// FileListener dups file descriptor, so the original one is closed
+	listener, err := net.FileListener(listenFiles[index])
+	if err != nil {
+		return nil, err
+	}
+	listenFiles[index].Close()
+	listenFdsUsed[index] = true
+
+	return listener, nil
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LogfmtEncoding is the name of logfmt encoder registered into zap, it could be used as encoding of zap.Config.
+const LogfmtEncoding = "logfmt"
+
+var logfmtPool = buffer.NewPool()
+
+func init() {
+	// logfmt encoder registered by others would be kept
+	zap.RegisterEncoder(LogfmtEncoding, func(config zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return NewLogfmtEncoder(config), nil
+	})
+}
+
+// NewLogfmtEncoder returns encoder which writes entries as key=value pairs separated by space.
+//
+// Values which contain space, equal sign, quote or non-printable runes are quoted.
+// Arrays, objects and reflected values are written as quoted JSON. Keys inside namespace are prefixed with
+// namespace and dot.
+func NewLogfmtEncoder(config zapcore.EncoderConfig) zapcore.Encoder {
+	return &logfmtEncoder{
+		EncoderConfig: &config,
+		buf:           logfmtPool.Get(),
+	}
+}
+
+type logfmtEncoder struct {
+	*zapcore.EncoderConfig
+	buf        *buffer.Buffer
+	namespaces []string
+}
+
+func (enc *logfmtEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	m := zapcore.NewMapObjectEncoder()
+	if err := m.AddArray(key, marshaler); err != nil {
+		return err
+	}
+
+	return enc.AddReflected(key, m.Fields[key])
+}
+
+func (enc *logfmtEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	m := zapcore.NewMapObjectEncoder()
+	if err := m.AddObject(key, marshaler); err != nil {
+		return err
+	}
+
+	return enc.AddReflected(key, m.Fields[key])
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, value []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(value))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, value []byte) {
+	enc.AddString(key, string(value))
+}
+
+func (enc *logfmtEncoder) AddBool(key string, value bool) {
+	enc.addKey(key)
+	enc.buf.AppendBool(value)
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, value complex128) {
+	enc.addKey(key)
+	enc.buf.AppendString(strconv.FormatComplex(value, 'f', -1, 128))
+}
+
+func (enc *logfmtEncoder) AddComplex64(key string, value complex64) {
+	enc.addKey(key)
+	enc.buf.AppendString(strconv.FormatComplex(complex128(value), 'f', -1, 64))
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, value time.Duration) {
+	if enc.EncodeDuration == nil {
+		enc.AddInt64(key, int64(value))
+		return
+	}
+
+	arr := &logfmtPrimitiveEncoder{}
+	enc.EncodeDuration(value, arr)
+	enc.addPrimitives(key, arr)
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, value float64) {
+	enc.addKey(key)
+	enc.appendFloat(value, 64)
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, value float32) {
+	enc.addKey(key)
+	enc.appendFloat(float64(value), 32)
+}
+
+func (enc *logfmtEncoder) AddInt(key string, value int) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt64(key string, value int64) {
+	enc.addKey(key)
+	enc.buf.AppendInt(value)
+}
+
+func (enc *logfmtEncoder) AddInt32(key string, value int32) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt16(key string, value int16) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddInt8(key string, value int8) {
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *logfmtEncoder) AddString(key, value string) {
+	enc.addKey(key)
+	enc.appendString(value)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, value time.Time) {
+	if enc.EncodeTime == nil {
+		enc.AddInt64(key, value.UnixNano())
+		return
+	}
+
+	arr := &logfmtPrimitiveEncoder{}
+	enc.EncodeTime(value, arr)
+	enc.addPrimitives(key, arr)
+}
+
+func (enc *logfmtEncoder) AddUint(key string, value uint) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint64(key string, value uint64) {
+	enc.addKey(key)
+	enc.buf.AppendUint(value)
+}
+
+func (enc *logfmtEncoder) AddUint32(key string, value uint32) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint16(key string, value uint16) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUint8(key string, value uint8) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddUintptr(key string, value uintptr) {
+	enc.AddUint64(key, uint64(value))
+}
+
+func (enc *logfmtEncoder) AddReflected(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	enc.AddString(key, string(bytes))
+	return nil
+}
+
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+	enc.namespaces = append(enc.namespaces, key)
+}
+
+func (enc *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := &logfmtEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		buf:           logfmtPool.Get(),
+		namespaces:    append([]string{}, enc.namespaces...),
+	}
+	clone.buf.Write(enc.buf.Bytes())
+
+	return clone
+}
+
+func (enc *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := &logfmtEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		buf:           logfmtPool.Get(),
+	}
+
+	if len(final.TimeKey) > 0 {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+
+	if len(final.LevelKey) > 0 {
+		if final.EncodeLevel == nil {
+			final.AddString(final.LevelKey, ent.Level.String())
+		} else {
+			arr := &logfmtPrimitiveEncoder{}
+			final.EncodeLevel(ent.Level, arr)
+			final.addPrimitives(final.LevelKey, arr)
+		}
+	}
+
+	if len(final.NameKey) > 0 && len(ent.LoggerName) > 0 {
+		if final.EncodeName == nil {
+			final.AddString(final.NameKey, ent.LoggerName)
+		} else {
+			arr := &logfmtPrimitiveEncoder{}
+			final.EncodeName(ent.LoggerName, arr)
+			final.addPrimitives(final.NameKey, arr)
+		}
+	}
+
+	if ent.Caller.Defined {
+		if len(final.CallerKey) > 0 {
+			if final.EncodeCaller == nil {
+				final.AddString(final.CallerKey, ent.Caller.TrimmedPath())
+			} else {
+				arr := &logfmtPrimitiveEncoder{}
+				final.EncodeCaller(ent.Caller, arr)
+				final.addPrimitives(final.CallerKey, arr)
+			}
+		}
+
+		if len(final.FunctionKey) > 0 {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+
+	if len(final.MessageKey) > 0 {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+
+	// context added with With()
+	if enc.buf.Len() > 0 {
+		if final.buf.Len() > 0 {
+			final.buf.AppendByte(' ')
+		}
+		final.buf.Write(enc.buf.Bytes())
+	}
+	final.namespaces = append(final.namespaces, enc.namespaces...)
+
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	final.namespaces = nil
+
+	if len(final.StacktraceKey) > 0 && len(ent.Stack) > 0 {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+
+	if !final.SkipLineEnding {
+		final.buf.AppendString(GetDefaultIfEmptyString(final.LineEnding, zapcore.DefaultLineEnding))
+	}
+
+	return final.buf, nil
+}
+
+// addKey writes separator and key prefixed with namespaces.
+func (enc *logfmtEncoder) addKey(key string) {
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+
+	for _, ns := range enc.namespaces {
+		enc.buf.AppendString(sanitizeLogfmtKey(ns))
+		enc.buf.AppendByte('.')
+	}
+	enc.buf.AppendString(sanitizeLogfmtKey(key))
+	enc.buf.AppendByte('=')
+}
+
+// addPrimitives writes values appended by encoders in EncoderConfig, multiple values are joined with space.
+func (enc *logfmtEncoder) addPrimitives(key string, arr *logfmtPrimitiveEncoder) {
+	enc.addKey(key)
+	if len(arr.elems) == 1 {
+		if s, ok := arr.elems[0].(string); ok {
+			enc.appendString(s)
+			return
+		}
+		enc.buf.AppendString(fmt.Sprint(arr.elems[0]))
+		return
+	}
+
+	strs := make([]string, 0, len(arr.elems))
+	for _, e := range arr.elems {
+		strs = append(strs, fmt.Sprint(e))
+	}
+	enc.appendString(strings.Join(strs, " "))
+}
+
+func (enc *logfmtEncoder) appendFloat(value float64, bitSize int) {
+	switch {
+	case math.IsNaN(value):
+		enc.buf.AppendString("NaN")
+	case math.IsInf(value, 1):
+		enc.buf.AppendString("+Inf")
+	case math.IsInf(value, -1):
+		enc.buf.AppendString("-Inf")
+	default:
+		enc.buf.AppendFloat(value, bitSize)
+	}
+}
+
+// appendString writes value, quote it if necessary.
+func (enc *logfmtEncoder) appendString(value string) {
+	if needsLogfmtQuote(value) {
+		enc.buf.AppendString(strconv.Quote(value))
+		return
+	}
+
+	enc.buf.AppendString(value)
+}
+
+func needsLogfmtQuote(value string) bool {
+	if len(value) < 1 {
+		return true
+	}
+
+	for _, r := range value {
+		if r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sanitizeLogfmtKey replaces runes which are not allowed in logfmt key with underscore.
+func sanitizeLogfmtKey(key string) string {
+	if len(key) < 1 {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtPrimitiveEncoder collects values appended by encoders in EncoderConfig.
+type logfmtPrimitiveEncoder struct {
+	elems []interface{}
+}
+
+func (arr *logfmtPrimitiveEncoder) AppendBool(v bool) { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendByteString(v []byte) {
+	arr.elems = append(arr.elems, string(v))
+}
+func (arr *logfmtPrimitiveEncoder) AppendComplex128(v complex128) { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendComplex64(v complex64)   { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendFloat64(v float64)       { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendFloat32(v float32)       { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendInt(v int)               { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendInt64(v int64)           { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendInt32(v int32)           { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendInt16(v int16)           { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendInt8(v int8)             { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendString(v string)         { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUint(v uint)             { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUint64(v uint64)         { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUint32(v uint32)         { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUint16(v uint16)         { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUint8(v uint8)           { arr.elems = append(arr.elems, v) }
+func (arr *logfmtPrimitiveEncoder) AppendUintptr(v uintptr)       { arr.elems = append(arr.elems, v) }
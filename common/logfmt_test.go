@@ -0,0 +1,85 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"testing"
+	"time"
+)
+
+func newLogfmtLoggerForTest(buf *bytes.Buffer, config zapcore.EncoderConfig) *zap.Logger {
+	return zap.New(zapcore.NewCore(NewLogfmtEncoder(config), zapcore.AddSync(buf), zapcore.DebugLevel))
+}
+
+func TestLogfmtEncoder_EncodeEntry(t *testing.T) {
+	preset, _ := GetEncodingPreset(EncodingPresetLogfmt)
+	config := preset.EncoderConfig
+	config.TimeKey = ""
+	config.CallerKey = ""
+
+	buf := &bytes.Buffer{}
+	logger := newLogfmtLoggerForTest(buf, config).Named("ut").With(zap.String("ctx", "value"))
+	logger.Info("hello world",
+		zap.String("empty", ""),
+		zap.String("quote", `a"b`),
+		zap.Int("int", -1),
+		zap.Uint8("uint", 1),
+		zap.Bool("bool", true),
+		zap.Float64("float", 1.5),
+		zap.Float64("nan", math.NaN()),
+		zap.Duration("duration", time.Second),
+		zap.Strings("list", []string{"a", "b"}),
+		zap.Binary("binary", []byte("ut")),
+		zap.ByteString("bytes", []byte("ut")),
+		zap.Complex128("complex", 1+2i),
+		zap.Error(errors.New("ut error")),
+		zap.Namespace("ns"),
+		zap.String("key with space", "value"))
+
+	assert.Equal(t, `level=info logger=ut msg="hello world" ctx=value empty="" quote="a\"b" int=-1 uint=1 `+
+		`bool=true float=1.5 nan=NaN duration=1s list="[\"a\",\"b\"]" binary="dXQ=" bytes=ut complex=(1+2i) `+
+		`error="ut error" ns.key_with_space=value`+"\n", buf.String())
+}
+
+func TestLogfmtEncoder_WithDefaultEncoders(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := newLogfmtLoggerForTest(buf, zapcore.EncoderConfig{
+		LevelKey:       "level",
+		NameKey:        "logger",
+		MessageKey:     "msg",
+		StacktraceKey:  "stack",
+		SkipLineEnding: true,
+	}).Named("ut")
+	logger.With(zap.Namespace("ns"), zap.Int("a", 1)).Warn("msg", zap.Duration("d", time.Nanosecond),
+		zap.Time("t", time.Unix(0, 1)), zap.Reflect("r", map[string]int{"k": 1}))
+
+	assert.Equal(t, `level=warn logger=ut msg=msg ns.a=1 ns.d=1 ns.t=1 ns.r="{\"k\":1}"`, buf.String())
+
+	// Clone should keep namespaces and context
+	clone := NewLogfmtEncoder(zapcore.EncoderConfig{}).(*logfmtEncoder)
+	clone.OpenNamespace("ns")
+	clone.AddInt("a", 1)
+	cloned := clone.Clone().(*logfmtEncoder)
+	cloned.AddInt("b", 2)
+	assert.Equal(t, "ns.a=1 ns.b=2", cloned.buf.String())
+	assert.Equal(t, "ns.a=1", clone.buf.String())
+}
+
+func TestLogfmtEncoder_WithPresetEncoders(t *testing.T) {
+	preset, _ := GetEncodingPreset(EncodingPresetLogfmt)
+
+	buf := &bytes.Buffer{}
+	zap.New(zapcore.NewCore(NewLogfmtEncoder(preset.EncoderConfig), zapcore.AddSync(buf), zapcore.DebugLevel),
+		zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)).Error("ut")
+
+	assert.Regexp(t, `^ts=\S+ level=error caller=common/logfmt_test.go:\d+ msg=ut stacktrace=".+"\n$`, buf.String())
+}
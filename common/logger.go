@@ -0,0 +1,477 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// LumberjackSinkScheme is the scheme of zap sink backed by lumberjack.
+//
+// Rotation policy is passed as query parameters which have the same names as YAML keys of lumberjack.Logger,
+// like rklumberjack:///var/log/app.log?maxsize=1024&maxbackups=3&maxage=7&compress=true&localtime=true
+//
+// Zap does not close sinks opened while building logger, so file sinks live for the whole process.
+const LumberjackSinkScheme = "rklumberjack"
+
+// RotatingSinkScheme is the scheme of zap sink backed by RotatingWriter.
+//
+// Rotation policy is passed as query parameters which have the same names as YAML keys of RotatingWriterConfig,
+// like rkrotate:///var/log/app.log?rotateEvery=daily&filenamePattern=app-%25Y%25m%25d.log&maxTotalSize=10240
+const RotatingSinkScheme = "rkrotate"
+
+var (
+	// registerSink is replaceable in unit tests
+	registerSink        = zap.RegisterSink
+	fileSinkSchemeLock  sync.Mutex
+	fileSinkSchemesDone = make(map[string]bool)
+
+	fileSinkLock sync.Mutex
+	fileSinks    = make(map[string]*fileSink)
+)
+
+// ZapLoggerConfig is a YAML decodable config of zap logger along with rotation policy of files.
+//
+// Example:
+// ---
+// name: my-logger
+// zap:
+//   level: info
+//   encoding: console
+//   outputPaths: ["stdout", "logs/app.log"]
+// lumberjack:
+//   maxsize: 1024
+//   maxbackups: 3
+//   maxage: 7
+//   compress: true
+//
+// Use rotation instead of lumberjack for time based rotation and disk quota:
+// rotation:
+//   maxsize: 1024
+//   rotateEvery: daily
+//   filenamePattern: app-%Y%m%d.log
+//   maxTotalSize: 10240
+//
+// Add async to write files in background:
+// async:
+//   bufferSize: 4096
+//   fullPolicy: dropOldest
+//   flushOnShutdown: true
+//
+// Add redact to mask sensitive data in all logs, key patterns and detectors of NewRedactorDefaultConfig are used if absent:
+// redact:
+//   keyPatterns: ["password", "secret"]
+//   detectors: ["bearerToken"]
+//
+// Add rateLimit to limit logs per rule and field values, with summary of suppressed counts:
+// rateLimit:
+//   summaryInterval: 1m
+//   rules:
+//     - message: "db timeout*"
+//       keys: ["tenant"]
+//       limit: 10
+//       interval: 1s
+type ZapLoggerConfig struct {
+	// Name of logger, level of named logger would be registered into DefaultLevelRegistry
+	Name string `yaml:"name" json:"name" mapstructure:"name"`
+	// Zap config which overrides NewZapDefaultConfig
+	Zap *ZapConfigOverride `yaml:"zap" json:"zap" mapstructure:"zap"`
+	// Lumberjack config which overrides NewLumberjackDefaultConfig, Filename is ignored since every file in
+	// output paths would be rotated with its own name
+	Lumberjack *lumberjack.Logger `yaml:"lumberjack" json:"lumberjack" mapstructure:"lumberjack"`
+	// Rotation config which replaces lumberjack if provided, Filename is ignored as well
+	Rotation *RotatingWriterConfig `yaml:"rotation" json:"rotation" mapstructure:"rotation"`
+	// Async config which makes files in output paths written in background if provided
+	Async *AsyncWriterConfig `yaml:"async" json:"async" mapstructure:"async"`
+	// Redact config which masks sensitive data in messages and fields of all logs if provided
+	Redact *RedactorConfig `yaml:"redact" json:"redact" mapstructure:"redact"`
+	// RateLimit config which limits logs per rule and field values if provided
+	RateLimit *RateLimitConfig `yaml:"rateLimit" json:"rateLimit" mapstructure:"rateLimit"`
+}
+
+// NewZapDefaultConfig returns zap production config with ISO8601 time encoder.
+func NewZapDefaultConfig() *zap.Config {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	return &config
+}
+
+// NewLumberjackDefaultConfig returns lumberjack config which rotates file at 1GB and keeps 3 backups for 7 days.
+func NewLumberjackDefaultConfig() *lumberjack.Logger {
+	return &lumberjack.Logger{
+		MaxSize:    1024,
+		MaxBackups: 3,
+		MaxAge:     7,
+		Compress:   true,
+		LocalTime:  true,
+	}
+}
+
+// NewZapLoggerFromConfig builds zap logger from boot config.
+//
+// Default configs are overridden with OverrideZapConfig and OverrideLumberjackConfig first. Then every file in
+// OutputPaths and ErrorOutputPaths is routed to a rotating sink registered with LumberjackSinkScheme, or
+// RotatingSinkScheme if rotation was provided. stdout,
+// stderr and paths with other schemes are kept as they are. Relative file paths are resolved against current
+// working directory.
+//
+// If name was provided, level of logger would be registered into DefaultLevelRegistry which could be changed at
+// runtime.
+func NewZapLoggerFromConfig(config *ZapLoggerConfig, opts ...zap.Option) (*zap.Logger, error) {
+	if err := registerFileSinks(); err != nil {
+		return nil, err
+	}
+
+	zapConfig := NewZapDefaultConfig()
+	lumberConfig := NewLumberjackDefaultConfig()
+	if config != nil {
+		if err := OverrideZapConfig(zapConfig, config.Zap); err != nil {
+			return nil, err
+		}
+		OverrideLumberjackConfig(lumberConfig, config.Lumberjack)
+
+		if config.Redact != nil {
+			redactor, err := NewRedactor(config.Redact)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithRedactor(redactor))
+		}
+
+		if config.RateLimit != nil {
+			opt, err := WithRateLimit(config.RateLimit)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, opt)
+		}
+
+		// loggers with the same name share the same level
+		if len(config.Name) > 0 {
+			zapConfig.Level = DefaultLevelRegistry.Register(config.Name, zapConfig.Level)
+		}
+	}
+
+	scheme, query := LumberjackSinkScheme, lumberjackSinkQuery(lumberConfig)
+	if config != nil && config.Rotation != nil {
+		scheme, query = RotatingSinkScheme, rotatingSinkQuery(config.Rotation)
+	}
+	if config != nil && config.Async != nil {
+		setAsyncSinkQuery(query, config.Async)
+	}
+
+	var err error
+	if zapConfig.OutputPaths, err = toFileSinkPaths(zapConfig.OutputPaths, scheme, query); err != nil {
+		return nil, err
+	}
+
+	if zapConfig.ErrorOutputPaths, err = toFileSinkPaths(zapConfig.ErrorOutputPaths, scheme, query); err != nil {
+		return nil, err
+	}
+
+	return zapConfig.Build(opts...)
+}
+
+// registerFileSinks registers LumberjackSinkScheme and RotatingSinkScheme into zap.
+//
+// Schemes registered successfully are skipped, so a failed registration would be retried by the next call.
+func registerFileSinks() error {
+	fileSinkSchemeLock.Lock()
+	defer fileSinkSchemeLock.Unlock()
+
+	for _, scheme := range []string{LumberjackSinkScheme, RotatingSinkScheme} {
+		if fileSinkSchemesDone[scheme] {
+			continue
+		}
+
+		factory := newLumberjackSink
+		if scheme == RotatingSinkScheme {
+			factory = newRotatingSink
+		}
+
+		if err := registerSink(scheme, factory); err != nil {
+			return err
+		}
+		fileSinkSchemesDone[scheme] = true
+	}
+
+	return nil
+}
+
+// lumberjackSinkQuery returns rotation policy of lumberjack as query of sink URL.
+func lumberjackSinkQuery(lumber *lumberjack.Logger) url.Values {
+	query := url.Values{}
+	query.Set("maxsize", strconv.Itoa(lumber.MaxSize))
+	query.Set("maxage", strconv.Itoa(lumber.MaxAge))
+	query.Set("maxbackups", strconv.Itoa(lumber.MaxBackups))
+	query.Set("localtime", strconv.FormatBool(lumber.LocalTime))
+	query.Set("compress", strconv.FormatBool(lumber.Compress))
+
+	return query
+}
+
+// rotatingSinkQuery returns rotation policy of RotatingWriter as query of sink URL.
+func rotatingSinkQuery(rotation *RotatingWriterConfig) url.Values {
+	query := url.Values{}
+	query.Set("maxsize", strconv.Itoa(rotation.MaxSize))
+	query.Set("maxage", strconv.Itoa(rotation.MaxAge))
+	query.Set("maxbackups", strconv.Itoa(rotation.MaxBackups))
+	query.Set("localtime", strconv.FormatBool(rotation.LocalTime))
+	query.Set("compress", strconv.FormatBool(rotation.Compress))
+	query.Set("rotateEvery", rotation.RotateEvery)
+	query.Set("filenamePattern", rotation.FilenamePattern)
+	query.Set("maxTotalSize", strconv.Itoa(rotation.MaxTotalSize))
+
+	return query
+}
+
+// setAsyncSinkQuery adds config of AsyncWriteSyncer into query of sink URL.
+func setAsyncSinkQuery(query url.Values, async *AsyncWriterConfig) {
+	query.Set("async", "true")
+	query.Set("asyncBufferSize", strconv.Itoa(async.BufferSize))
+	query.Set("asyncFullPolicy", async.FullPolicy)
+	query.Set("asyncFlushOnShutdown", strconv.FormatBool(async.FlushOnShutdown))
+}
+
+// toFileSinkPaths converts file paths into sink URL with scheme and query.
+func toFileSinkPaths(paths []string, scheme string, query url.Values) ([]string, error) {
+	res := make([]string, 0, len(paths))
+
+	for _, p := range paths {
+		if p == "stdout" || p == "stderr" {
+			res = append(res, p)
+			continue
+		}
+
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse %q as a URL: %v", p, err)
+		}
+
+		switch u.Scheme {
+		case "":
+		case "file":
+			p = u.Path
+		default:
+			res = append(res, p)
+			continue
+		}
+
+		if p, err = filepath.Abs(p); err != nil {
+			return nil, err
+		}
+
+		sinkURL := url.URL{
+			Scheme:   scheme,
+			Path:     filepath.ToSlash(p),
+			RawQuery: query.Encode(),
+		}
+		res = append(res, sinkURL.String())
+	}
+
+	return res, nil
+}
+
+// fileSink is zap.Sink backed by a rotating writer of file.
+//
+// Sinks of the same file are shared, since rotating one file with multiple writers would lose logs.
+// Opening a file which is already opened with a different scheme or rotation policy would cause an error.
+//
+// File is closed when the last reference is closed, however zap never closes sinks opened by zap.Config.Build,
+// so sinks of loggers built from config live for the whole process.
+type fileSink struct {
+	writer   io.WriteCloser
+	filename string
+	policy   string
+	refs     int
+}
+
+// newLumberjackSink is sink factory of LumberjackSinkScheme.
+func newLumberjackSink(u *url.URL) (zap.Sink, error) {
+	return openFileSink(u, func(filename string, query url.Values) (io.WriteCloser, error) {
+		logger := &lumberjack.Logger{
+			Filename: filename,
+		}
+
+		err := parseSinkQuery(query, map[string]*int{
+			"maxsize":    &logger.MaxSize,
+			"maxage":     &logger.MaxAge,
+			"maxbackups": &logger.MaxBackups,
+		}, map[string]*bool{
+			"localtime": &logger.LocalTime,
+			"compress":  &logger.Compress,
+		})
+
+		return logger, err
+	})
+}
+
+// newRotatingSink is sink factory of RotatingSinkScheme.
+func newRotatingSink(u *url.URL) (zap.Sink, error) {
+	return openFileSink(u, func(filename string, query url.Values) (io.WriteCloser, error) {
+		config := &RotatingWriterConfig{
+			Filename:        filename,
+			RotateEvery:     query.Get("rotateEvery"),
+			FilenamePattern: query.Get("filenamePattern"),
+		}
+
+		if err := parseSinkQuery(query, map[string]*int{
+			"maxsize":      &config.MaxSize,
+			"maxage":       &config.MaxAge,
+			"maxbackups":   &config.MaxBackups,
+			"maxTotalSize": &config.MaxTotalSize,
+		}, map[string]*bool{
+			"localtime": &config.LocalTime,
+			"compress":  &config.Compress,
+		}); err != nil {
+			return nil, err
+		}
+
+		return NewRotatingWriter(config)
+	})
+}
+
+// openFileSink returns the shared sink of file in URL, writer would be created with newWriter if absent.
+func openFileSink(u *url.URL, newWriter func(string, url.Values) (io.WriteCloser, error)) (zap.Sink, error) {
+	filename := filepath.FromSlash(u.Path)
+	if len(filename) < 1 {
+		return nil, fmt.Errorf("empty file path of %s sink", u.Scheme)
+	}
+
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+
+	query := u.Query()
+	// Encode sorts query by key, so that equal policies are compared equal
+	policy := u.Scheme + "?" + query.Encode()
+
+	if sink, ok := fileSinks[filename]; ok {
+		if sink.policy != policy {
+			return nil, fmt.Errorf("file %q is already opened by sink %s, conflicts with %s", filename, sink.policy, policy)
+		}
+		sink.refs++
+		return sink, nil
+	}
+
+	writer, err := newWriter(filename, query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s sink: %v", u.Scheme, err)
+	}
+
+	async, err := toAsyncWriter(writer, query)
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("invalid %s sink: %v", u.Scheme, err)
+	}
+
+	sink := &fileSink{
+		writer:   async,
+		filename: filename,
+		policy:   policy,
+		refs:     1,
+	}
+	fileSinks[filename] = sink
+
+	return sink, nil
+}
+
+// toAsyncWriter wraps writer with AsyncWriteSyncer if async is true in query.
+func toAsyncWriter(writer io.WriteCloser, query url.Values) (io.WriteCloser, error) {
+	async := false
+	config := &AsyncWriterConfig{
+		FullPolicy: query.Get("asyncFullPolicy"),
+	}
+
+	if err := parseSinkQuery(query, map[string]*int{
+		"asyncBufferSize": &config.BufferSize,
+	}, map[string]*bool{
+		"async":                &async,
+		"asyncFlushOnShutdown": &config.FlushOnShutdown,
+	}); err != nil || !async {
+		return writer, err
+	}
+
+	ws, ok := writer.(zapcore.WriteSyncer)
+	if !ok {
+		ws = &nopSyncWriteCloser{WriteCloser: writer}
+	}
+
+	return NewAsyncWriteSyncer(ws, config)
+}
+
+// nopSyncWriteCloser is zapcore.WriteSyncer of io.WriteCloser which writes without buffering.
+type nopSyncWriteCloser struct {
+	io.WriteCloser
+}
+
+// Sync is noop.
+func (w *nopSyncWriteCloser) Sync() error {
+	return nil
+}
+
+// parseSinkQuery parses integer and boolean query parameters into destinations, absent parameters are skipped.
+func parseSinkQuery(query url.Values, ints map[string]*int, bools map[string]*bool) error {
+	for key, dest := range ints {
+		if v := query.Get(key); len(v) > 0 {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*dest = i
+		}
+	}
+
+	for key, dest := range bools {
+		if v := query.Get(key); len(v) > 0 {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*dest = b
+		}
+	}
+
+	return nil
+}
+
+// Write writes into underlying writer.
+func (sink *fileSink) Write(p []byte) (int, error) {
+	return sink.writer.Write(p)
+}
+
+// Sync flushes underlying writer if supported, lumberjack.Logger writes into file without buffering.
+func (sink *fileSink) Sync() error {
+	if syncer, ok := sink.writer.(zapcore.WriteSyncer); ok {
+		return syncer.Sync()
+	}
+
+	return nil
+}
+
+// Close closes underlying file when the last reference is closed.
+func (sink *fileSink) Close() error {
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+
+	sink.refs--
+	if sink.refs > 0 {
+		return nil
+	}
+
+	if fileSinks[sink.filename] == sink {
+		delete(fileSinks, sink.filename)
+	}
+	return sink.writer.Close()
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"testing"
+)
+
+func TestNewZapLoggerFromConfig_WithNilConfig(t *testing.T) {
+	logger, err := NewZapLoggerFromConfig(nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, logger)
+}
+
+func TestNewZapLoggerFromConfig_HappyCase(t *testing.T) {
+	dir := t.TempDir()
+	config := &ZapLoggerConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+zap:
+  level: debug
+  encoding: json
+  outputPaths: ["`+path.Join(dir, "app.log")+`"]
+  errorOutputPaths: ["file://`+path.Join(dir, "app.log")+`"]
+lumberjack:
+  maxsize: 1
+  maxbackups: 1
+`), config))
+
+	logger, err := NewZapLoggerFromConfig(config)
+	assert.Nil(t, err)
+
+	// Sink of the same file should be shared
+	sink := fileSinks[path.Join(dir, "app.log")]
+	assert.NotNil(t, sink)
+	assert.Equal(t, 2, sink.refs)
+	assert.Equal(t, 1, sink.writer.(*lumberjack.Logger).MaxSize)
+	assert.Equal(t, 1, sink.writer.(*lumberjack.Logger).MaxBackups)
+
+	logger.Debug("ut-message")
+	assert.Nil(t, logger.Sync())
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, "app.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "ut-message")
+
+	assert.Nil(t, sink.Close())
+	assert.Nil(t, sink.Close())
+	assert.NotContains(t, fileSinks, path.Join(dir, "app.log"))
+}
+
+func TestNewZapLoggerFromConfig_WithConflictedPolicy(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "app.log")
+
+	sink, err := newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: filename, RawQuery: "maxsize=1&compress=true"})
+	assert.Nil(t, err)
+	defer sink.Close()
+
+	// With the same policy in different order
+	same, err := newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: filename, RawQuery: "compress=true&maxsize=1"})
+	assert.Nil(t, err)
+	assert.Nil(t, same.Close())
+
+	// With different policy
+	_, err = newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: filename, RawQuery: "maxsize=2"})
+	assert.NotNil(t, err)
+
+	// With different scheme
+	_, err = newRotatingSink(&url.URL{Scheme: RotatingSinkScheme, Path: filename, RawQuery: "maxsize=1&compress=true"})
+	assert.NotNil(t, err)
+
+	_, err = NewZapLoggerFromConfig(&ZapLoggerConfig{
+		Zap:        &ZapConfigOverride{OutputPaths: []string{filename}},
+		Lumberjack: &lumberjack.Logger{MaxSize: 3},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestToFileSinkPaths(t *testing.T) {
+	res, err := toFileSinkPaths([]string{"stdout", "stderr", "ut://path", "/ut/app.log"},
+		LumberjackSinkScheme, lumberjackSinkQuery(&lumberjack.Logger{
+			MaxSize:  1,
+			Compress: true,
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"stdout", "stderr", "ut://path"}, res[:3])
+
+	u, err := url.Parse(res[3])
+	assert.Nil(t, err)
+	assert.Equal(t, LumberjackSinkScheme, u.Scheme)
+	assert.Equal(t, "/ut/app.log", u.Path)
+	assert.Equal(t, "1", u.Query().Get("maxsize"))
+	assert.Equal(t, "true", u.Query().Get("compress"))
+
+	// With invalid URL
+	_, err = toFileSinkPaths([]string{"ut://%"}, LumberjackSinkScheme, lumberjackSinkQuery(NewLumberjackDefaultConfig()))
+	assert.NotNil(t, err)
+}
+
+func TestNewLumberjackSink_WithInvalidQuery(t *testing.T) {
+	_, err := newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme})
+	assert.NotNil(t, err)
+
+	_, err = newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: "/ut/app.log", RawQuery: "maxsize=x"})
+	assert.NotNil(t, err)
+
+	_, err = newLumberjackSink(&url.URL{Scheme: LumberjackSinkScheme, Path: "/ut/app.log", RawQuery: "compress=x"})
+	assert.NotNil(t, err)
+}
+
+func TestRegisterFileSinks_WithFailure(t *testing.T) {
+	defer func(origin func(string, func(*url.URL) (zap.Sink, error)) error, done map[string]bool) {
+		registerSink, fileSinkSchemesDone = origin, done
+	}(registerSink, fileSinkSchemesDone)
+	fileSinkSchemesDone = make(map[string]bool)
+
+	// Failure should not be cached
+	registerSink = func(string, func(*url.URL) (zap.Sink, error)) error {
+		return errors.New("ut-error")
+	}
+	assert.NotNil(t, registerFileSinks())
+	assert.Empty(t, fileSinkSchemesDone)
+
+	registered := make([]string, 0)
+	registerSink = func(scheme string, _ func(*url.URL) (zap.Sink, error)) error {
+		registered = append(registered, scheme)
+		return nil
+	}
+	assert.Nil(t, registerFileSinks())
+	assert.Nil(t, registerFileSinks())
+	assert.Equal(t, []string{LumberjackSinkScheme, RotatingSinkScheme}, registered)
+}
+
+func TestNewZapLoggerFromConfig_WithInvalidOutputPath(t *testing.T) {
+	_, err := NewZapLoggerFromConfig(&ZapLoggerConfig{
+		Zap: &ZapConfigOverride{
+			OutputPaths: []string{"ut-unknown://path"},
+		},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestNewZapLoggerFromConfig_WithName(t *testing.T) {
+	defer DefaultLevelRegistry.Unregister("ut-logger")
+
+	logger, err := NewZapLoggerFromConfig(&ZapLoggerConfig{Name: "ut-logger"})
+	assert.Nil(t, err)
+
+	_, err = DefaultLevelRegistry.SetLevel("ut-logger", zap.DebugLevel, 0)
+	assert.Nil(t, err)
+	assert.True(t, logger.Core().Enabled(zap.DebugLevel))
+}
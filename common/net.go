@@ -0,0 +1,297 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// IPFamilyV4 selects IPv4 addresses
+	IPFamilyV4 = "ipv4"
+	// IPFamilyV6 selects IPv6 addresses
+	IPFamilyV6 = "ipv6"
+	// DefaultOutboundTargetV4 is a public IPv4 address used to find the outbound route
+	DefaultOutboundTargetV4 = "8.8.8.8:53"
+	// DefaultOutboundTargetV6 is a public IPv6 address used to find the outbound route
+	DefaultOutboundTargetV6 = "[2001:4860:4860::8888]:53"
+)
+
+// DefaultVirtualInterfaces are glob patterns of interfaces created by container runtimes, CNI plugins and
+// hypervisors, which are excluded by default.
+var DefaultVirtualInterfaces = []string{
+	"docker*", "br-*", "veth*", "virbr*", "cni*", "flannel*", "cali*", "vxlan*", "weave*", "kube-ipvs*", "lxcbr*",
+	"vmnet*", "vboxnet*",
+}
+
+// LocalIPOptions selects addresses of local network interfaces.
+//
+// Example:
+// ---
+// interfaces: ["eth*", "en*"]
+// cidrs: ["10.0.0.0/8"]
+// family: ipv4
+type LocalIPOptions struct {
+	// Interfaces are names or glob patterns of interfaces in syntax of path.Match, all interfaces are selected if empty.
+	// Addresses of interfaces matched with earlier patterns are preferred.
+	Interfaces []string `yaml:"interfaces" json:"interfaces"`
+	// CIDRs select addresses in any of the networks, all addresses are selected if empty
+	CIDRs []string `yaml:"cidrs" json:"cidrs"`
+	// Family is ipv4 or ipv6, both are selected if empty and IPv4 is preferred
+	Family string `yaml:"family" json:"family"`
+	// VirtualInterfaces are glob patterns of virtual interfaces, DefaultVirtualInterfaces would be used if nil
+	VirtualInterfaces []string `yaml:"virtualInterfaces" json:"virtualInterfaces"`
+	// IncludeVirtual selects addresses of virtual interfaces
+	IncludeVirtual bool `yaml:"includeVirtual" json:"includeVirtual"`
+	// IncludeLoopback selects loopback addresses
+	IncludeLoopback bool `yaml:"includeLoopback" json:"includeLoopback"`
+	// IncludeLinkLocal selects link-local addresses like 169.254.0.0/16 and fe80::/10
+	IncludeLinkLocal bool `yaml:"includeLinkLocal" json:"includeLinkLocal"`
+	// IncludeDown selects addresses of interfaces which are down
+	IncludeDown bool `yaml:"includeDown" json:"includeDown"`
+	// OutboundTarget is host:port used by GetLocalIPWithOptions to prefer the source address of route to target,
+	// like DefaultOutboundTargetV4. Addresses are preferred in sorted order if empty or route not found.
+	OutboundTarget string `yaml:"outboundTarget" json:"outboundTarget"`
+}
+
+// LocalIP is an address of local network interface.
+type LocalIP struct {
+	// IP is the address
+	IP net.IP `json:"ip"`
+	// Network is the network of address in CIDR notation
+	Network string `json:"network"`
+	// Family is ipv4 or ipv6
+	Family string `json:"family"`
+	// Interface is the name of interface
+	Interface string `json:"interface"`
+	// InterfaceIndex is the index of interface assigned by OS
+	InterfaceIndex int `json:"interfaceIndex"`
+	// Up is true if interface is up
+	Up bool `json:"up"`
+	// Loopback is true if address or interface is loopback
+	Loopback bool `json:"loopback"`
+	// LinkLocal is true if address is link-local
+	LinkLocal bool `json:"linkLocal"`
+	// Virtual is true if interface matched with virtual interfaces
+	Virtual bool `json:"virtual"`
+
+	rank int
+}
+
+// String returns IP in string.
+func (l *LocalIP) String() string {
+	return l.IP.String()
+}
+
+// ListLocalIPs returns addresses of local network interfaces selected by options, preferred address comes first.
+// Default options would be used if options is nil.
+//
+// Addresses are sorted by order of matched interface patterns, family (IPv4 first), interface index and address,
+// so that the result is deterministic.
+func ListLocalIPs(options *LocalIPOptions) ([]*LocalIP, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]*LocalIP, 0)
+	for i := range interfaces {
+		iface := interfaces[i]
+		addresses, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addresses {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			candidates = append(candidates, newLocalIP(iface, ipNet))
+		}
+	}
+
+	return filterLocalIPs(candidates, options)
+}
+
+// GetLocalIPWithOptions returns the preferred address of ListLocalIPs, error would be returned if none selected.
+//
+// If OutboundTarget was provided, the source address of route to target is preferred if it was selected by options,
+// which picks the right interface on multi-homed hosts.
+func GetLocalIPWithOptions(options *LocalIPOptions) (*LocalIP, error) {
+	res, err := ListLocalIPs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) < 1 {
+		return nil, errors.New("no local IP matched with options")
+	}
+
+	if options != nil && len(options.OutboundTarget) > 0 {
+		if ip, err := GetOutboundIP(options.OutboundTarget); err == nil {
+			return selectLocalIP(res, ip), nil
+		}
+	}
+
+	return res[0], nil
+}
+
+// GetOutboundIP returns the source address which kernel would use to reach target like 8.8.8.8:53.
+//
+// It connects a UDP socket to target which only looks up route, no packets are sent.
+func GetOutboundIP(target string) (net.IP, error) {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("failed to find outbound address to %s", target)
+	}
+
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		return ip4, nil
+	}
+
+	return addr.IP, nil
+}
+
+// selectLocalIP returns the address equal to ip, the first one would be returned if not found.
+func selectLocalIP(candidates []*LocalIP, ip net.IP) *LocalIP {
+	for i := range candidates {
+		if candidates[i].IP.Equal(ip) {
+			return candidates[i]
+		}
+	}
+
+	return candidates[0]
+}
+
+// newLocalIP creates LocalIP with metadata of interface.
+func newLocalIP(iface net.Interface, ipNet *net.IPNet) *LocalIP {
+	res := &LocalIP{
+		IP:             ipNet.IP,
+		Network:        (&net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}).String(),
+		Family:         IPFamilyV6,
+		Interface:      iface.Name,
+		InterfaceIndex: iface.Index,
+		Up:             iface.Flags&net.FlagUp != 0,
+		Loopback:       iface.Flags&net.FlagLoopback != 0 || ipNet.IP.IsLoopback(),
+		LinkLocal:      ipNet.IP.IsLinkLocalUnicast(),
+	}
+
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		res.IP = ip4
+		res.Family = IPFamilyV4
+	}
+
+	return res
+}
+
+// filterLocalIPs selects and sorts candidates with options.
+func filterLocalIPs(candidates []*LocalIP, options *LocalIPOptions) ([]*LocalIP, error) {
+	if options == nil {
+		options = &LocalIPOptions{}
+	}
+
+	family := strings.ToLower(options.Family)
+	if len(family) > 0 && family != IPFamilyV4 && family != IPFamilyV6 {
+		return nil, fmt.Errorf("invalid family %q, should be %s or %s", options.Family, IPFamilyV4, IPFamilyV6)
+	}
+
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range options.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q, %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	virtualPatterns := options.VirtualInterfaces
+	if virtualPatterns == nil {
+		virtualPatterns = DefaultVirtualInterfaces
+	}
+
+	for _, pattern := range append(append([]string{}, options.Interfaces...), virtualPatterns...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface pattern %q: %v", pattern, err)
+		}
+	}
+
+	res := make([]*LocalIP, 0)
+	for _, candidate := range candidates {
+		candidate.Virtual = matchAnyGlob(virtualPatterns, candidate.Interface) >= 0
+		candidate.rank = 0
+		if len(options.Interfaces) > 0 {
+			if candidate.rank = matchAnyGlob(options.Interfaces, candidate.Interface); candidate.rank < 0 {
+				continue
+			}
+		}
+
+		if (len(family) > 0 && candidate.Family != family) ||
+			(candidate.Virtual && !options.IncludeVirtual) ||
+			(candidate.Loopback && !options.IncludeLoopback) ||
+			(candidate.LinkLocal && !options.IncludeLinkLocal) ||
+			(!candidate.Up && !options.IncludeDown) {
+			continue
+		}
+
+		if len(networks) > 0 && !containsIP(networks, candidate.IP) {
+			continue
+		}
+
+		res = append(res, candidate)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].rank != res[j].rank {
+			return res[i].rank < res[j].rank
+		}
+		if res[i].Family != res[j].Family {
+			return res[i].Family == IPFamilyV4
+		}
+		if res[i].InterfaceIndex != res[j].InterfaceIndex {
+			return res[i].InterfaceIndex < res[j].InterfaceIndex
+		}
+		return bytes.Compare(res[i].IP, res[j].IP) < 0
+	})
+
+	return res, nil
+}
+
+// matchAnyGlob returns index of the first pattern matched with str, -1 would be returned if none matched.
+// Patterns follow the syntax of path.Match and should be validated before.
+func matchAnyGlob(patterns []string, str string) int {
+	for i := range patterns {
+		if matched, _ := path.Match(patterns[i], str); matched {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// containsIP returns true if ip is in any of networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for i := range networks {
+		if networks[i].Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
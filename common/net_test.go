@@ -0,0 +1,125 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func newTestLocalIPs() []*LocalIP {
+	up := net.FlagUp
+	return []*LocalIP{
+		newLocalIP(net.Interface{Index: 1, Name: "lo", Flags: up | net.FlagLoopback}, &net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}),
+		newLocalIP(net.Interface{Index: 2, Name: "eth0", Flags: up}, &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}),
+		newLocalIP(net.Interface{Index: 2, Name: "eth0", Flags: up}, &net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)}),
+		newLocalIP(net.Interface{Index: 2, Name: "eth0", Flags: up}, &net.IPNet{IP: net.ParseIP("10.0.0.9"), Mask: net.CIDRMask(24, 32)}),
+		newLocalIP(net.Interface{Index: 3, Name: "docker0", Flags: up}, &net.IPNet{IP: net.ParseIP("172.17.0.1"), Mask: net.CIDRMask(16, 32)}),
+		newLocalIP(net.Interface{Index: 4, Name: "eth1", Flags: 0}, &net.IPNet{IP: net.ParseIP("10.1.0.9"), Mask: net.CIDRMask(24, 32)}),
+		newLocalIP(net.Interface{Index: 5, Name: "wlan0", Flags: up}, &net.IPNet{IP: net.ParseIP("192.168.1.9"), Mask: net.CIDRMask(24, 32)}),
+	}
+}
+
+func localIPStrings(ips []*LocalIP) []string {
+	res := make([]string, 0)
+	for i := range ips {
+		res = append(res, ips[i].String())
+	}
+	return res
+}
+
+func TestNewLocalIP(t *testing.T) {
+	res := newTestLocalIPs()
+
+	assert.True(t, res[0].Loopback)
+	assert.Equal(t, "10.0.0.0/24", res[3].Network)
+	assert.Equal(t, IPFamilyV4, res[3].Family)
+	assert.Len(t, res[3].IP, net.IPv4len)
+	assert.Equal(t, IPFamilyV6, res[1].Family)
+	assert.True(t, res[2].LinkLocal)
+	assert.False(t, res[5].Up)
+}
+
+func TestFilterLocalIPs_WithDefaultOptions(t *testing.T) {
+	res, err := filterLocalIPs(newTestLocalIPs(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.9", "192.168.1.9", "fd00::2"}, localIPStrings(res))
+	assert.False(t, res[0].Virtual)
+}
+
+func TestFilterLocalIPs_WithOptions(t *testing.T) {
+	// interface patterns and preference
+	res, err := filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{Interfaces: []string{"wlan*", "eth?"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.168.1.9", "10.0.0.9", "fd00::2"}, localIPStrings(res))
+
+	// family
+	res, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{Family: "IPv6", IncludeLinkLocal: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fd00::2", "fe80::1"}, localIPStrings(res))
+
+	// cidr, down and virtual
+	res, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{
+		CIDRs:          []string{"10.1.0.0/16", "172.16.0.0/12"},
+		IncludeDown:    true,
+		IncludeVirtual: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"172.17.0.1", "10.1.0.9"}, localIPStrings(res))
+	assert.True(t, res[0].Virtual)
+
+	// empty virtual interfaces
+	res, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{VirtualInterfaces: []string{}, IncludeLoopback: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1", "10.0.0.9", "172.17.0.1", "192.168.1.9", "fd00::2"}, localIPStrings(res))
+
+	// With invalid options
+	_, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{Family: "ipx"})
+	assert.NotNil(t, err)
+	_, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{CIDRs: []string{"10.0.0.0"}})
+	assert.NotNil(t, err)
+	_, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{Interfaces: []string{"eth["}})
+	assert.NotNil(t, err)
+	_, err = filterLocalIPs(newTestLocalIPs(), &LocalIPOptions{VirtualInterfaces: []string{"docker["}})
+	assert.NotNil(t, err)
+}
+
+func TestListLocalIPs(t *testing.T) {
+	res, err := ListLocalIPs(&LocalIPOptions{IncludeLoopback: true})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, res)
+
+	_, err = GetLocalIPWithOptions(&LocalIPOptions{Interfaces: []string{"not-exist"}})
+	assert.NotNil(t, err)
+}
+
+func TestGetOutboundIP(t *testing.T) {
+	ip, err := GetOutboundIP("127.0.0.1:53")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", ip.String())
+
+	_, err = GetOutboundIP("invalid")
+	assert.NotNil(t, err)
+}
+
+func TestGetLocalIPWithOptions_WithOutboundTarget(t *testing.T) {
+	res, err := GetLocalIPWithOptions(&LocalIPOptions{IncludeLoopback: true, OutboundTarget: "127.0.0.1:53"})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", res.String())
+	assert.Equal(t, IPFamilyV4, res.Family)
+
+	// fallback to sorted addresses if route not found
+	res, err = GetLocalIPWithOptions(&LocalIPOptions{IncludeLoopback: true, OutboundTarget: "invalid"})
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+}
+
+func TestSelectLocalIP(t *testing.T) {
+	candidates := newTestLocalIPs()
+	assert.Equal(t, "192.168.1.9", selectLocalIP(candidates, net.ParseIP("192.168.1.9")).String())
+	assert.Equal(t, "127.0.0.1", selectLocalIP(candidates, net.ParseIP("8.8.8.8")).String())
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// AlphabetLetters contains upper and lower case letters, used by RandString
+	AlphabetLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// AlphabetAlphanumeric contains upper and lower case letters and digits
+	AlphabetAlphanumeric = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// AlphabetHex contains lower case hex digits
+	AlphabetHex = "0123456789abcdef"
+	// AlphabetBase32 contains characters of base32 defined in RFC 4648
+	AlphabetBase32 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	// AlphabetURLSafe contains characters of URL safe base64 defined in RFC 4648
+	AlphabetURLSafe = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// fastRandPool keeps math/rand sources seeded from crypto/rand, since rand.Rand is not safe for concurrent use and
+// the global source is guarded by a single lock.
+var fastRandPool = sync.Pool{
+	New: func() interface{} {
+		seed := time.Now().UnixNano()
+		var b [8]byte
+		if _, err := crand.Read(b[:]); err == nil {
+			seed = int64(binary.LittleEndian.Uint64(b[:]))
+		}
+
+		return rand.New(rand.NewSource(seed))
+	},
+}
+
+// validateAlphabet checks alphabet, duplicated characters are not allowed since they bias the result.
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 1 || len(alphabet) > 256 {
+		return errors.New("length of alphabet should be in range [1, 256]")
+	}
+
+	seen := [256]bool{}
+	for i := 0; i < len(alphabet); i++ {
+		if seen[alphabet[i]] {
+			return errors.New("duplicated characters in alphabet")
+		}
+		seen[alphabet[i]] = true
+	}
+
+	return nil
+}
+
+// SecureRandString generates random string of n characters in alphabet with crypto/rand, which could be used as
+// tokens and passwords.
+//
+// Characters are sampled without bias by rejecting random values out of range of alphabet.
+func SecureRandString(n int, alphabet string) (string, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return "", err
+	}
+
+	if n < 1 {
+		return "", nil
+	}
+
+	res := make([]byte, 0, n)
+	mask := byte(1<<uint(bits.Len(uint(len(alphabet)-1))) - 1)
+
+	// read a little more than needed, since some values would be rejected
+	buf := make([]byte, n+n/2+8)
+	for len(res) < n {
+		if _, err := crand.Read(buf); err != nil {
+			return "", err
+		}
+
+		for _, b := range buf {
+			if idx := int(b & mask); idx < len(alphabet) {
+				res = append(res, alphabet[idx])
+				if len(res) == n {
+					break
+				}
+			}
+		}
+	}
+
+	return string(res), nil
+}
+
+// FastRandString generates random string of n characters in alphabet with math/rand, empty string would be returned
+// if alphabet is invalid.
+//
+// It is safe for concurrent use and much faster than SecureRandString, but the result is predictable, do not use it
+// for secrets.
+func FastRandString(n int, alphabet string) string {
+	if n < 1 || validateAlphabet(alphabet) != nil {
+		return ""
+	}
+
+	r := fastRandPool.Get().(*rand.Rand)
+	defer fastRandPool.Put(r)
+
+	res := make([]byte, 0, n)
+	idxBits := bits.Len(uint(len(alphabet) - 1))
+	if idxBits < 1 {
+		// alphabet with single character
+		idxBits = 1
+	}
+	mask := int64(1)<<uint(idxBits) - 1
+	idxPerRand := 63 / idxBits
+
+	// consume every 63 random bits by idxBits to reduce calls of source
+	for cache, remain := r.Int63(), idxPerRand; len(res) < n; {
+		if remain < 1 {
+			cache, remain = r.Int63(), idxPerRand
+		}
+
+		if idx := int(cache & mask); idx < len(alphabet) {
+			res = append(res, alphabet[idx])
+		}
+		cache >>= uint(idxBits)
+		remain--
+	}
+
+	return string(res)
+}
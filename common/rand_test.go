@@ -0,0 +1,75 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func assertInAlphabet(t *testing.T, alphabet, str string) {
+	for _, c := range str {
+		assert.True(t, strings.ContainsRune(alphabet, c), str)
+	}
+}
+
+func TestSecureRandString(t *testing.T) {
+	for _, alphabet := range []string{AlphabetLetters, AlphabetAlphanumeric, AlphabetHex, AlphabetBase32, AlphabetURLSafe, "x"} {
+		res, err := SecureRandString(100, alphabet)
+		assert.Nil(t, err)
+		assert.Len(t, res, 100)
+		assertInAlphabet(t, alphabet, res)
+	}
+
+	res, err := SecureRandString(0, AlphabetHex)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	// With invalid alphabet
+	for _, alphabet := range []string{"", "aab", strings.Repeat("a", 257)} {
+		_, err = SecureRandString(10, alphabet)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSecureRandString_Unbiased(t *testing.T) {
+	// 3 characters do not divide 256, biased sampling with modulo would prefer the first character
+	res, err := SecureRandString(30000, "abc")
+	assert.Nil(t, err)
+
+	for _, c := range "abc" {
+		count := strings.Count(res, string(c))
+		assert.True(t, count > 9500 && count < 10500, count)
+	}
+}
+
+func TestFastRandString(t *testing.T) {
+	for _, alphabet := range []string{AlphabetLetters, AlphabetAlphanumeric, AlphabetHex, AlphabetBase32, AlphabetURLSafe, "x"} {
+		res := FastRandString(100, alphabet)
+		assert.Len(t, res, 100)
+		assertInAlphabet(t, alphabet, res)
+	}
+
+	assert.Empty(t, FastRandString(0, AlphabetHex))
+	assert.Empty(t, FastRandString(10, "aab"))
+	assert.NotEqual(t, FastRandString(32, AlphabetHex), FastRandString(32, AlphabetHex))
+}
+
+func TestFastRandString_Concurrent(t *testing.T) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				assert.Len(t, FastRandString(16, AlphabetAlphanumeric), 16)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,441 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitSummaryMessage is the message of summary logs of suppressed logs
+	RateLimitSummaryMessage = "logs suppressed by rate limit"
+
+	// maxRateLimitBuckets is the number of buckets of field values, logs of new values share the overflow bucket of
+	// rule once it is reached
+	maxRateLimitBuckets = 4096
+)
+
+// RateLimitRule limits the number of logs matched with Logger and Message in every Interval.
+//
+// Logs are counted separately for every combination of values of Keys, so that a rule like
+// {message: "db timeout*", keys: ["tenant"], limit: 10} allows at most 10 logs per second per tenant, no matter
+// how many different messages are matched.
+type RateLimitRule struct {
+	// Logger is glob pattern of logger name, * and ? are supported, matches all loggers if empty
+	Logger string `yaml:"logger" json:"logger" mapstructure:"logger"`
+	// Message is glob pattern of message, * and ? are supported, matches all messages if empty
+	Message string `yaml:"message" json:"message" mapstructure:"message"`
+	// Keys are field keys whose values partition logs, fields added by With are included
+	Keys []string `yaml:"keys" json:"keys" mapstructure:"keys"`
+	// Limit is the number of logs allowed in every interval, all matched logs are suppressed if zero
+	Limit int `yaml:"limit" json:"limit" mapstructure:"limit"`
+	// Interval is the duration of window, like 1s and 1m, 1s would be used if empty
+	Interval string `yaml:"interval" json:"interval" mapstructure:"interval"`
+}
+
+// RateLimitConfig is a YAML decodable config of NewRateLimitCore.
+//
+// Example:
+// ---
+// summaryInterval: 1m
+// rules:
+//   - message: "db timeout*"
+//     keys: ["tenant"]
+//     limit: 10
+//     interval: 1s
+type RateLimitConfig struct {
+	// Rules are matched in order and the first matched rule applies, logs matched with no rule are not limited
+	Rules []*RateLimitRule `yaml:"rules" json:"rules" mapstructure:"rules"`
+	// SummaryInterval is the interval of summary of suppressed counts, summary is disabled if empty
+	SummaryInterval string `yaml:"summaryInterval" json:"summaryInterval" mapstructure:"summaryInterval"`
+}
+
+type rateLimitRule struct {
+	logger   *regexp.Regexp
+	message  *regexp.Regexp
+	keys     []string
+	limit    int
+	interval time.Duration
+}
+
+type rateLimitBucket struct {
+	rule        *rateLimitRule
+	values      []string
+	overflow    bool
+	entry       zapcore.Entry
+	windowStart time.Time
+	count       int
+	suppressed  uint64
+}
+
+// rateLimiter keeps counters shared by cores derived with With.
+type rateLimiter struct {
+	root            zapcore.Core
+	rules           []*rateLimitRule
+	summaryInterval time.Duration
+	now             func() time.Time
+	afterFunc       func(time.Duration, func())
+
+	lock           sync.Mutex
+	buckets        map[string]*rateLimitBucket
+	lastSummary    time.Time
+	summaryPending bool
+}
+
+type rateLimitCore struct {
+	zapcore.Core
+	limiter *rateLimiter
+	fields  []zap.Field
+}
+
+// NewRateLimitCore wraps core so that logs matched with rules are rate limited.
+//
+// If summaryInterval was provided, summary of suppressed counts is written as a warning once the interval passed,
+// with the next log, Sync or a timer if nothing was logged after suppression. Summary contains the last suppressed message, values of keys and the suppressed count.
+func NewRateLimitCore(core zapcore.Core, config *RateLimitConfig) (zapcore.Core, error) {
+	if core == nil {
+		return nil, errors.New("nil core")
+	}
+
+	limiter := &rateLimiter{
+		root:  core,
+		rules: make([]*rateLimitRule, 0),
+		now:   time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+		buckets: make(map[string]*rateLimitBucket),
+	}
+
+	if config == nil {
+		config = &RateLimitConfig{}
+	}
+
+	if len(config.SummaryInterval) > 0 {
+		d, err := time.ParseDuration(config.SummaryInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid summaryInterval %q", config.SummaryInterval)
+		}
+		limiter.summaryInterval = d
+	}
+
+	for i, rule := range config.Rules {
+		if rule == nil {
+			continue
+		}
+
+		if rule.Limit < 0 {
+			return nil, fmt.Errorf("negative limit of rule[%d] not allowed", i)
+		}
+
+		interval := time.Second
+		if len(rule.Interval) > 0 {
+			d, err := time.ParseDuration(rule.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid interval %q of rule[%d]", rule.Interval, i)
+			}
+			interval = d
+		}
+
+		limiter.rules = append(limiter.rules, &rateLimitRule{
+			logger:   globToRegexp(rule.Logger),
+			message:  globToRegexp(rule.Message),
+			keys:     append([]string{}, rule.Keys...),
+			limit:    rule.Limit,
+			interval: interval,
+		})
+	}
+
+	return &rateLimitCore{
+		Core:    core,
+		limiter: limiter,
+	}, nil
+}
+
+// WithRateLimit returns zap.Option which wraps core of logger with NewRateLimitCore.
+func WithRateLimit(config *RateLimitConfig) (zap.Option, error) {
+	// validate config before wrapping
+	if _, err := NewRateLimitCore(zapcore.NewNopCore(), config); err != nil {
+		return nil, err
+	}
+
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		res, _ := NewRateLimitCore(core, config)
+		return res
+	}), nil
+}
+
+// globToRegexp converts glob pattern with * and ? into regular expression, empty pattern matches everything.
+func globToRegexp(glob string) *regexp.Regexp {
+	if len(glob) < 1 {
+		glob = "*"
+	}
+
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+
+	return regexp.MustCompile("^(?s:" + pattern + ")$")
+}
+
+// With keeps fields for matching keys of rules, counters are shared with c.
+func (c *rateLimitCore) With(fields []zap.Field) zapcore.Core {
+	return &rateLimitCore{
+		Core:    c.Core.With(fields),
+		limiter: c.limiter,
+		fields:  append(append(make([]zap.Field, 0, len(c.fields)+len(fields)), c.fields...), fields...),
+	}
+}
+
+// Check checks entry with wrapped core, rate limit is applied at Write since it depends on fields.
+func (c *rateLimitCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checkWrapped(c.Core, entry, checked,
+		func(inner *zapcore.CheckedEntry, entry zapcore.Entry, fields []zap.Field) error {
+			return c.write(entry, fields, func() error {
+				return writeChecked(inner, entry, fields)
+			})
+		})
+}
+
+// Write writes entry into core unless it was suppressed.
+func (c *rateLimitCore) Write(entry zapcore.Entry, fields []zap.Field) error {
+	return c.write(entry, fields, func() error {
+		return c.Core.Write(entry, fields)
+	})
+}
+
+// write calls write unless entry was suppressed, summaries which are due are written as well.
+func (c *rateLimitCore) write(entry zapcore.Entry, fields []zap.Field, write func() error) error {
+	allowed, summaries := c.limiter.allow(entry, c.fields, fields)
+
+	var err error
+	if allowed {
+		err = write()
+	}
+
+	if summaryErr := c.limiter.writeSummaries(summaries); err == nil {
+		err = summaryErr
+	}
+
+	return err
+}
+
+// Sync writes pending summaries and syncs core.
+func (c *rateLimitCore) Sync() error {
+	err := c.limiter.writeSummaries(c.limiter.summarize(true))
+	if syncErr := c.Core.Sync(); err == nil {
+		err = syncErr
+	}
+
+	return err
+}
+
+// allow counts entry and returns whether it should be written, along with summaries which are due.
+func (l *rateLimiter) allow(entry zapcore.Entry, withFields, fields []zap.Field) (bool, []*rateLimitBucket) {
+	var rule *rateLimitRule
+	for _, r := range l.rules {
+		if r.logger.MatchString(entry.LoggerName) && r.message.MatchString(entry.Message) {
+			rule = r
+			break
+		}
+	}
+
+	if rule == nil {
+		return true, l.summarize(false)
+	}
+
+	values := fieldValues(rule.keys, withFields, fields)
+	key := fmt.Sprintf("%p\x00%s", rule, strings.Join(values, "\x00"))
+
+	l.lock.Lock()
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	overflow := false
+	if !ok && len(l.buckets) >= maxRateLimitBuckets {
+		l.purge(now)
+
+		// buckets are bounded, logs of new values share the overflow bucket of rule
+		if len(l.buckets) >= maxRateLimitBuckets {
+			key, overflow = fmt.Sprintf("%p\x01overflow", rule), true
+			bucket, ok = l.buckets[key]
+		}
+	}
+
+	if !ok {
+		bucket = &rateLimitBucket{
+			rule:        rule,
+			values:      values,
+			overflow:    overflow,
+			windowStart: now,
+		}
+		l.buckets[key] = bucket
+	}
+
+	if now.Sub(bucket.windowStart) >= rule.interval {
+		bucket.windowStart = now
+		bucket.count = 0
+	}
+
+	bucket.count++
+	allowed := bucket.count <= rule.limit
+	if !allowed {
+		bucket.suppressed++
+		bucket.entry = entry
+		l.scheduleSummary(l.summaryInterval)
+	}
+	l.lock.Unlock()
+
+	return allowed, l.summarize(false)
+}
+
+// scheduleSummary starts timer of summary after d if not started, l.lock should be held.
+func (l *rateLimiter) scheduleSummary(d time.Duration) {
+	if l.summaryInterval <= 0 || l.summaryPending {
+		return
+	}
+
+	l.summaryPending = true
+	l.afterFunc(d, l.summarizeLater)
+}
+
+// summarizeLater writes summaries at timer, timer is started again if summary was written by logs in the meantime.
+func (l *rateLimiter) summarizeLater() {
+	l.lock.Lock()
+	l.summaryPending = false
+	if !l.lastSummary.IsZero() {
+		if wait := l.summaryInterval - l.now().Sub(l.lastSummary); wait > 0 {
+			l.scheduleSummary(wait)
+			l.lock.Unlock()
+			return
+		}
+	}
+	l.lock.Unlock()
+
+	// errors are ignored since there is no place to report them
+	l.writeSummaries(l.summarize(true))
+}
+
+// summarize returns copies of buckets with suppressed logs and resets their counts, if summary interval passed or
+// force is true.
+func (l *rateLimiter) summarize(force bool) []*rateLimitBucket {
+	if l.summaryInterval <= 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	if l.lastSummary.IsZero() {
+		l.lastSummary = now
+	}
+
+	if !force && now.Sub(l.lastSummary) < l.summaryInterval {
+		return nil
+	}
+	l.lastSummary = now
+
+	res := make([]*rateLimitBucket, 0)
+	for _, bucket := range l.buckets {
+		if bucket.suppressed > 0 {
+			summary := *bucket
+			res = append(res, &summary)
+			bucket.suppressed = 0
+		}
+	}
+	l.purge(now)
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].entry.Message != res[j].entry.Message {
+			return res[i].entry.Message < res[j].entry.Message
+		}
+		return strings.Join(res[i].values, "\x00") < strings.Join(res[j].values, "\x00")
+	})
+
+	return res
+}
+
+// purge removes buckets whose window expired and have no suppressed logs to report.
+func (l *rateLimiter) purge(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.suppressed < 1 && now.Sub(bucket.windowStart) >= bucket.rule.interval {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// writeSummaries writes summaries into root core as warnings, cores are decided by Check of root core.
+func (l *rateLimiter) writeSummaries(summaries []*rateLimitBucket) error {
+	var err error
+	for _, summary := range summaries {
+		fields := []zap.Field{
+			zap.String("suppressedMessage", summary.entry.Message),
+			zap.Uint64("suppressed", summary.suppressed),
+		}
+		if summary.overflow {
+			// values of keys were mixed in overflow bucket
+			fields = append(fields, zap.Bool("overflow", true))
+		} else {
+			for i := range summary.rule.keys {
+				fields = append(fields, zap.String(summary.rule.keys[i], summary.values[i]))
+			}
+		}
+
+		entry := zapcore.Entry{
+			Level:      zapcore.WarnLevel,
+			Time:       l.now(),
+			LoggerName: summary.entry.LoggerName,
+			Message:    RateLimitSummaryMessage,
+		}
+
+		checked := l.root.Check(entry, nil)
+		if checked == nil {
+			continue
+		}
+
+		if writeErr := writeChecked(checked, entry, fields); err == nil {
+			err = writeErr
+		}
+	}
+
+	return err
+}
+
+// fieldValues returns values of keys in fields as string, fields added later win, absent keys are empty.
+func fieldValues(keys []string, fieldSets ...[]zap.Field) []string {
+	res := make([]string, len(keys))
+	if len(keys) < 1 {
+		return res
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, fields := range fieldSets {
+		for i := range fields {
+			for _, key := range keys {
+				if fields[i].Key == key {
+					fields[i].AddTo(enc)
+					break
+				}
+			}
+		}
+	}
+
+	for i, key := range keys {
+		if v, ok := enc.Fields[key]; ok {
+			res[i] = fmt.Sprint(v)
+		}
+	}
+
+	return res
+}
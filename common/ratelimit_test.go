@@ -0,0 +1,274 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/yaml.v2"
+	"testing"
+	"time"
+)
+
+func newTestRateLimitLogger(t *testing.T, config *RateLimitConfig) (*zap.Logger, *observer.ObservedLogs, *testClock) {
+	inner, logs := observer.New(zapcore.InfoLevel)
+	core, err := NewRateLimitCore(inner, config)
+	assert.Nil(t, err)
+
+	clock := &testClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	core.(*rateLimitCore).limiter.now = clock.Now
+
+	return zap.New(core), logs, clock
+}
+
+func TestNewRateLimitCore_WithInvalidConfig(t *testing.T) {
+	_, err := NewRateLimitCore(nil, nil)
+	assert.NotNil(t, err)
+
+	for _, config := range []*RateLimitConfig{
+		{SummaryInterval: "x"},
+		{SummaryInterval: "-1s"},
+		{Rules: []*RateLimitRule{{Limit: -1}}},
+		{Rules: []*RateLimitRule{{Interval: "x"}}},
+	} {
+		_, err = NewRateLimitCore(zapcore.NewNopCore(), config)
+		assert.NotNil(t, err)
+
+		_, err = WithRateLimit(config)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRateLimitCore_PerKey(t *testing.T) {
+	logger, logs, clock := newTestRateLimitLogger(t, &RateLimitConfig{
+		Rules: []*RateLimitRule{
+			{Message: "db timeout*", Keys: []string{"tenant"}, Limit: 2},
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		logger.Info("db timeout after 1s", zap.String("tenant", "a"))
+		logger.With(zap.String("tenant", "b")).Info("db timeout after 1s")
+		// not matched
+		logger.Info("other message", zap.String("tenant", "a"))
+	}
+
+	assert.Equal(t, 2, logs.FilterField(zap.String("tenant", "a")).FilterMessage("db timeout after 1s").Len())
+	assert.Equal(t, 2, logs.FilterField(zap.String("tenant", "b")).Len())
+	assert.Equal(t, 5, logs.FilterMessage("other message").Len())
+
+	// next window
+	clock.Add(time.Second)
+	logger.Info("db timeout after 1s", zap.String("tenant", "a"))
+	assert.Equal(t, 3, logs.FilterField(zap.String("tenant", "a")).FilterMessage("db timeout after 1s").Len())
+
+	// different messages matched with the same rule share the bucket
+	logger.Info("db timeout after 2s", zap.String("tenant", "a"))
+	logger.Info("db timeout after 3s", zap.String("tenant", "a"))
+	assert.Equal(t, 1, logs.FilterMessage("db timeout after 2s").Len())
+	assert.Equal(t, 0, logs.FilterMessage("db timeout after 3s").Len())
+}
+
+func TestRateLimitCore_WithSampler(t *testing.T) {
+	inner, logs := observer.New(zapcore.InfoLevel)
+	core, err := NewRateLimitCore(zapcore.NewSamplerWithOptions(inner, time.Minute, 2, 0), &RateLimitConfig{
+		Rules: []*RateLimitRule{{Message: "other message", Limit: 1}},
+	})
+	assert.Nil(t, err)
+	logger := zap.New(core)
+
+	for i := 0; i < 50; i++ {
+		logger.Info("ut-message")
+	}
+
+	// sampler drops entries after the first two
+	assert.Equal(t, 2, logs.FilterMessage("ut-message").Len())
+}
+
+func TestRateLimitCore_WithTee(t *testing.T) {
+	infoCore, infoLogs := observer.New(zapcore.InfoLevel)
+	errorCore, errorLogs := observer.New(zapcore.ErrorLevel)
+	core, err := NewRateLimitCore(zapcore.NewTee(infoCore, errorCore), &RateLimitConfig{
+		Rules: []*RateLimitRule{{Message: "ut-error", Limit: 1}},
+	})
+	assert.Nil(t, err)
+	logger := zap.New(core)
+
+	logger.Info("ut-info")
+	logger.Error("ut-error")
+	logger.Error("ut-error")
+
+	// levels of tee children should be respected
+	assert.Equal(t, 2, infoLogs.Len())
+	assert.Equal(t, 1, errorLogs.Len())
+	assert.Equal(t, "ut-error", errorLogs.All()[0].Message)
+}
+
+func TestRateLimitCore_WithLoggerAndZeroLimit(t *testing.T) {
+	logger, logs, _ := newTestRateLimitLogger(t, &RateLimitConfig{
+		Rules: []*RateLimitRule{
+			{Logger: "noisy-*"},
+		},
+	})
+
+	logger.Named("noisy-db").Info("message")
+	logger.Named("quiet").Info("message")
+	logger.Debug("disabled")
+
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, "quiet", logs.All()[0].LoggerName)
+}
+
+func TestRateLimitCore_WithSummary(t *testing.T) {
+	logger, logs, clock := newTestRateLimitLogger(t, &RateLimitConfig{
+		SummaryInterval: "1m",
+		Rules: []*RateLimitRule{
+			{Message: "db timeout*", Keys: []string{"tenant", "absent"}, Limit: 1, Interval: "1m"},
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		logger.Info("db timeout", zap.String("tenant", "a"))
+	}
+	logger.Info("db timeout again", zap.String("tenant", "a"))
+	logger.Info("db timeout", zap.Int("tenant", 1))
+	assert.Equal(t, 2, logs.Len())
+
+	// summary is written with the next log after interval
+	clock.Add(time.Minute)
+	logger.Info("other message")
+
+	summaries := logs.FilterMessage(RateLimitSummaryMessage).AllUntimed()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, zapcore.WarnLevel, summaries[0].Level)
+	assert.Equal(t, map[string]interface{}{
+		"suppressedMessage": "db timeout again",
+		"suppressed":        uint64(3),
+		"tenant":            "a",
+		"absent":            "",
+	}, summaries[0].ContextMap())
+
+	// summary is written at Sync as well
+	logger.Info("db timeout", zap.String("tenant", "a"))
+	logger.Info("db timeout", zap.String("tenant", "a"))
+	assert.Nil(t, logger.Sync())
+	assert.Equal(t, 2, logs.FilterMessage(RateLimitSummaryMessage).Len())
+
+	// nothing suppressed
+	assert.Nil(t, logger.Sync())
+	assert.Equal(t, 2, logs.FilterMessage(RateLimitSummaryMessage).Len())
+}
+
+func TestRateLimitCore_WithSummaryTimer(t *testing.T) {
+	logger, logs, clock := newTestRateLimitLogger(t, &RateLimitConfig{
+		SummaryInterval: "1m",
+		Rules:           []*RateLimitRule{{Message: "db timeout", Limit: 1, Interval: "1m"}},
+	})
+	limiter := logger.Core().(*rateLimitCore).limiter
+
+	timers := make([]func(), 0)
+	limiter.afterFunc = func(d time.Duration, f func()) {
+		assert.Equal(t, time.Minute, d)
+		timers = append(timers, f)
+	}
+
+	// timer is started once per summary
+	for i := 0; i < 3; i++ {
+		logger.Info("db timeout")
+	}
+	assert.Len(t, timers, 1)
+
+	// summary is written by timer without further logs
+	clock.Add(time.Minute)
+	timers[0]()
+	summaries := logs.FilterMessage(RateLimitSummaryMessage).AllUntimed()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, uint64(2), summaries[0].ContextMap()["suppressed"])
+
+	// timer is started again if summary was written by logs in the meantime
+	logger.Info("db timeout")
+	logger.Info("db timeout")
+	assert.Len(t, timers, 2)
+	clock.Add(time.Minute)
+	logger.Info("db timeout")
+	assert.Equal(t, 2, logs.FilterMessage(RateLimitSummaryMessage).Len())
+
+	clock.Add(30 * time.Second)
+	limiter.afterFunc = func(d time.Duration, f func()) {
+		assert.Equal(t, 30*time.Second, d)
+		timers = append(timers, f)
+	}
+	timers[1]()
+	assert.Len(t, timers, 3)
+	assert.Equal(t, 2, logs.FilterMessage(RateLimitSummaryMessage).Len())
+}
+
+func TestRateLimiter_Purge(t *testing.T) {
+	logger, logs, clock := newTestRateLimitLogger(t, &RateLimitConfig{
+		SummaryInterval: "1m",
+		Rules: []*RateLimitRule{
+			{Keys: []string{"id"}, Limit: 1},
+		},
+	})
+	limiter := logger.Core().(*rateLimitCore).limiter
+
+	for i := 0; i < maxRateLimitBuckets; i++ {
+		logger.Info("message", zap.Int("id", i))
+	}
+	assert.Len(t, limiter.buckets, maxRateLimitBuckets)
+
+	// new values share the overflow bucket within the window
+	logger.Info("message", zap.Int("id", -2))
+	logger.Info("message", zap.Int("id", -3))
+	assert.Len(t, limiter.buckets, maxRateLimitBuckets+1)
+	assert.Equal(t, 1, logs.FilterMessage("message").FilterField(zap.Int("id", -2)).Len())
+	assert.Equal(t, 0, logs.FilterMessage("message").FilterField(zap.Int("id", -3)).Len())
+
+	clock.Add(time.Second)
+	logger.Info("message", zap.Int("id", -1))
+	// overflow bucket has suppressed logs to report
+	assert.Len(t, limiter.buckets, 2)
+
+	assert.Nil(t, logger.Sync())
+	summaries := logs.FilterMessage(RateLimitSummaryMessage).AllUntimed()
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"suppressedMessage": "message",
+		"suppressed":        uint64(1),
+		"overflow":          true,
+	}, summaries[0].ContextMap())
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	assert.True(t, globToRegexp("").MatchString("any/thing"))
+	assert.True(t, globToRegexp("GET /v1/*").MatchString("GET /v1/users/1"))
+	assert.True(t, globToRegexp("db?").MatchString("db1"))
+	assert.False(t, globToRegexp("db?").MatchString("db12"))
+	assert.False(t, globToRegexp("a.c").MatchString("abc"))
+}
+
+func TestNewZapLoggerFromConfig_WithRateLimit(t *testing.T) {
+	config := &ZapLoggerConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+rateLimit:
+  summaryInterval: 1m
+  rules:
+    - message: "db timeout*"
+      keys: ["tenant"]
+      limit: 10
+`), config))
+
+	logger, err := NewZapLoggerFromConfig(config)
+	assert.Nil(t, err)
+	_, ok := logger.Core().(*rateLimitCore)
+	assert.True(t, ok)
+
+	// With invalid config
+	_, err = NewZapLoggerFromConfig(&ZapLoggerConfig{RateLimit: &RateLimitConfig{SummaryInterval: "x"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,534 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultRedactMask is the value which replaces sensitive data
+	DefaultRedactMask = "******"
+	// RedactTag is the option of struct tag rk which marks a field as sensitive, like `rk:"redact"`
+	RedactTag = "redact"
+	// ValueDetectorBearerToken detects token in bearer authorization, like "Bearer <token>"
+	ValueDetectorBearerToken = "bearerToken"
+	// ValueDetectorBasicAuth detects credential in basic authorization header and password in URL, like
+	// "Authorization: Basic <credential>", quoted "Basic <credential>" and "https://user:<password>@host".
+	// Credential should be base64 of at least 8 characters which decodes to <username>:<password>
+	ValueDetectorBasicAuth = "basicAuth"
+	// ValueDetectorCreditCard detects card numbers which pass Luhn check, numbers should be grouped like
+	// "4111 1111 1111 1111" or start with known prefixes of Visa, Mastercard, Amex and Discover.
+	// It is not in defaults, since IDs and timestamps could be detected as well
+	ValueDetectorCreditCard = "creditCard"
+
+	maxRedactDepth = 32
+)
+
+var (
+	// DefaultRedactor is used by ConvertStructToZapFields
+	DefaultRedactor, _ = NewRedactor(nil)
+
+	bearerTokenRegex = regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	basicAuthRegex   = regexp.MustCompile(`(?i)(\bauthorization["']?\s*[:=]\s*["']?basic\s+|["']basic\s+|^basic\s+)([A-Za-z0-9+/]{8,}={0,2})`)
+	urlPasswordRegex = regexp.MustCompile(`(://[^/?#@:\s]*:)[^/?#@\s]+(@)`)
+	creditCardRegex  = regexp.MustCompile(`\b(?:\d{4}(?:[ -]\d{4}){3}|3[47]\d{2}[ -]\d{6}[ -]\d{5}|` +
+		`4\d{15}|5[1-5]\d{14}|2[2-7]\d{14}|3[47]\d{13}|6(?:011|5\d{2})\d{12})\b`)
+	// redactKeyCamelRegex matches boundaries of words in camel case key, like accessToken and APIKey
+	redactKeyCamelRegex = regexp.MustCompile(`([a-z0-9])([A-Z])|([A-Z])([A-Z][a-z])`)
+
+	valueDetectorLock sync.RWMutex
+	valueDetectors    = map[string]ValueDetector{
+		ValueDetectorBearerToken: func(value, mask string) string {
+			return bearerTokenRegex.ReplaceAllString(value, "${1}"+mask)
+		},
+		ValueDetectorBasicAuth: func(value, mask string) string {
+			value = basicAuthRegex.ReplaceAllStringFunc(value, func(match string) string {
+				groups := basicAuthRegex.FindStringSubmatch(match)
+				if !isBasicAuthCredential(groups[2]) {
+					return match
+				}
+				return groups[1] + mask
+			})
+			return urlPasswordRegex.ReplaceAllString(value, "${1}"+mask+"${2}")
+		},
+		ValueDetectorCreditCard: func(value, mask string) string {
+			return creditCardRegex.ReplaceAllStringFunc(value, func(number string) string {
+				if luhnValid(number) {
+					return mask
+				}
+				return number
+			})
+		},
+	}
+
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// ValueDetector replaces sensitive parts of value with mask, value should be returned as it is if nothing detected.
+type ValueDetector func(value, mask string) string
+
+// RegisterValueDetector registers detector with name, error would be returned if name was registered already.
+func RegisterValueDetector(name string, detector ValueDetector) error {
+	if len(name) < 1 || detector == nil {
+		return errors.New("empty name or nil value detector")
+	}
+
+	valueDetectorLock.Lock()
+	defer valueDetectorLock.Unlock()
+
+	if _, ok := valueDetectors[name]; ok {
+		return fmt.Errorf("value detector %q already registered", name)
+	}
+
+	valueDetectors[name] = detector
+	return nil
+}
+
+// ListValueDetectors returns names of registered detectors in sorted order.
+func ListValueDetectors() []string {
+	valueDetectorLock.RLock()
+	defer valueDetectorLock.RUnlock()
+
+	res := make([]string, 0, len(valueDetectors))
+	for name := range valueDetectors {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// RedactorConfig is a YAML decodable config of Redactor.
+//
+// Example:
+// ---
+// mask: "******"
+// keyPatterns: ["password", "secret", "^x-api-key$"]
+// detectors: ["bearerToken", "basicAuth", "creditCard"]
+type RedactorConfig struct {
+	// Mask replaces sensitive data, DefaultRedactMask would be used if empty
+	Mask string `yaml:"mask" json:"mask" mapstructure:"mask"`
+	// KeyPatterns are case-insensitive regular expressions, values of matched keys would be masked entirely.
+	// Keys are matched in lower case with words of camel case separated by underscore, like access_token for
+	// accessToken. Patterns of NewRedactorDefaultConfig would be used if nil
+	KeyPatterns []string `yaml:"keyPatterns" json:"keyPatterns" mapstructure:"keyPatterns"`
+	// Detectors are names of registered value detectors applied on string values, detectors of
+	// NewRedactorDefaultConfig would be used if nil
+	Detectors []string `yaml:"detectors" json:"detectors" mapstructure:"detectors"`
+}
+
+// NewRedactorDefaultConfig returns config which masks keys containing common credential words, like password,
+// db_password and accessToken, and applies detectors of bearer token and basic auth.
+func NewRedactorDefaultConfig() *RedactorConfig {
+	return &RedactorConfig{
+		Mask: DefaultRedactMask,
+		KeyPatterns: []string{
+			redactKeyWord("passw(or)?d"),
+			redactKeyWord("secret"),
+			redactKeyWord("token"),
+			redactKeyWord("api[-_]?key"),
+			redactKeyWord("private[-_]?key"),
+			redactKeyWord("authorization"),
+			redactKeyWord("cookie"),
+			redactKeyWord("credentials?"),
+		},
+		Detectors: []string{
+			ValueDetectorBearerToken,
+			ValueDetectorBasicAuth,
+		},
+	}
+}
+
+// redactKeyWord returns pattern which matches word as a whole word of key, so that secret matches client_secret
+// but not secretary.
+func redactKeyWord(word string) string {
+	return "(^|[^a-z0-9])" + word + "([^a-z0-9]|$)"
+}
+
+// Redactor masks sensitive data in structs, strings and zap fields.
+//
+// A value is masked entirely if its key matches one of key patterns or the struct field is tagged with
+// `rk:"redact"`. Otherwise, detectors replace sensitive parts of string values.
+type Redactor struct {
+	mask      string
+	keys      []*regexp.Regexp
+	detectors []ValueDetector
+}
+
+// NewRedactor returns Redactor with config, NewRedactorDefaultConfig would be used if config is nil.
+func NewRedactor(config *RedactorConfig) (*Redactor, error) {
+	defaults := NewRedactorDefaultConfig()
+	if config == nil {
+		config = defaults
+	}
+
+	keyPatterns, detectorNames := config.KeyPatterns, config.Detectors
+	if keyPatterns == nil {
+		keyPatterns = defaults.KeyPatterns
+	}
+	if detectorNames == nil {
+		detectorNames = defaults.Detectors
+	}
+
+	r := &Redactor{
+		mask:      GetDefaultIfEmptyString(config.Mask, DefaultRedactMask),
+		keys:      make([]*regexp.Regexp, 0, len(keyPatterns)),
+		detectors: make([]ValueDetector, 0, len(detectorNames)),
+	}
+
+	for _, pattern := range keyPatterns {
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key pattern %q: %v", pattern, err)
+		}
+		r.keys = append(r.keys, regex)
+	}
+
+	valueDetectorLock.RLock()
+	defer valueDetectorLock.RUnlock()
+
+	for _, name := range detectorNames {
+		detector, ok := valueDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown value detector %q", name)
+		}
+		r.detectors = append(r.detectors, detector)
+	}
+
+	return r, nil
+}
+
+// RedactKey returns true if value of key should be masked entirely.
+func (r *Redactor) RedactKey(key string) bool {
+	if len(r.keys) < 1 {
+		return false
+	}
+
+	key = strings.ToLower(redactKeyCamelRegex.ReplaceAllString(key, "${1}${3}_${2}${4}"))
+	for _, regex := range r.keys {
+		if regex.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedactString replaces sensitive parts of str detected by detectors.
+func (r *Redactor) RedactString(str string) string {
+	for _, detector := range r.detectors {
+		str = detector(str, r.mask)
+	}
+
+	return str
+}
+
+// RedactValue returns a copy of v with sensitive data masked.
+//
+// Structs are converted into map[string]interface{} with the same keys as encoding/json, maps with string keys,
+// slices and arrays are converted into map[string]interface{} and []interface{}. Values implementing json.Marshaler
+// or encoding.TextMarshaler and other kinds are returned as they are.
+func (r *Redactor) RedactValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	return r.redactValue(reflect.ValueOf(v), 0)
+}
+
+// RedactFields returns copy of fields with sensitive data masked.
+//
+// Keys of objects marshaled by zapcore.ObjectMarshaler are not inspected.
+func (r *Redactor) RedactFields(fields []zap.Field) []zap.Field {
+	res := make([]zap.Field, len(fields))
+	for i := range fields {
+		res[i] = r.redactField(fields[i])
+	}
+
+	return res
+}
+
+func (r *Redactor) redactField(field zap.Field) zap.Field {
+	switch field.Type {
+	case zapcore.NamespaceType, zapcore.SkipType:
+		return field
+	}
+
+	if r.RedactKey(field.Key) {
+		return zap.String(field.Key, r.mask)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = r.RedactString(field.String)
+	case zapcore.ByteStringType:
+		// bytes are shared with caller, so that they are replaced instead of modified
+		if bytes, ok := field.Interface.([]byte); ok {
+			if redacted := r.RedactString(string(bytes)); redacted != string(bytes) {
+				field.Interface = []byte(redacted)
+			}
+		}
+	case zapcore.ReflectType:
+		field.Interface = r.RedactValue(field.Interface)
+	case zapcore.StringerType, zapcore.ErrorType:
+		// keep original field if nothing detected, since errors may carry verbose stack
+		var str string
+		if stringer, ok := field.Interface.(fmt.Stringer); ok {
+			str = stringer.String()
+		} else if err, ok := field.Interface.(error); ok {
+			str = err.Error()
+		}
+
+		if redacted := r.RedactString(str); redacted != str {
+			return zap.String(field.Key, redacted)
+		}
+	}
+
+	return field
+}
+
+func (r *Redactor) redactValue(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if depth > maxRedactDepth {
+		return r.mask
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+
+		if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+			return v.Interface()
+		}
+		v = v.Elem()
+	}
+
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return r.RedactString(v.String())
+	case reflect.Struct:
+		res := make(map[string]interface{})
+		r.redactStruct(v, res, depth)
+		return res
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+
+		res := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if r.RedactKey(key) {
+				res[key] = r.mask
+				continue
+			}
+			res[key] = r.redactValue(iter.Value(), depth+1)
+		}
+		return res
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 || (v.Kind() == reflect.Slice && v.IsNil()) {
+			return v.Interface()
+		}
+
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = r.redactValue(v.Index(i), depth+1)
+		}
+		return res
+	}
+
+	return v.Interface()
+}
+
+// redactStruct puts exported fields of struct v into res, fields of embedded structs are promoted as encoding/json.
+func (r *Redactor) redactStruct(v reflect.Value, res map[string]interface{}, depth int) {
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		name, omitEmpty, ok := jsonFieldName(structField)
+		if !ok {
+			continue
+		}
+
+		value := v.Field(i)
+		if omitEmpty && isEmptyValue(value) {
+			continue
+		}
+
+		if hasRkTagOption(structField, RedactTag) || r.RedactKey(name) {
+			res[name] = r.mask
+			continue
+		}
+
+		// promote fields of embedded struct without json name
+		if structField.Anonymous && len(strings.Split(structField.Tag.Get("json"), ",")[0]) < 1 {
+			for value.Kind() == reflect.Ptr && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct && !value.Type().Implements(jsonMarshalerType) {
+				r.redactStruct(value, res, depth+1)
+				continue
+			}
+		}
+
+		res[name] = r.redactValue(value, depth+1)
+	}
+}
+
+// jsonFieldName returns name of struct field in encoding/json and whether omitempty was set.
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty, ok bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+
+	if len(field.PkgPath) > 0 && !field.Anonymous {
+		return "", false, false
+	}
+
+	tokens := strings.Split(tag, ",")
+	name = tokens[0]
+	if len(name) < 1 {
+		name = field.Name
+	}
+
+	for _, opt := range tokens[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty, true
+}
+
+// hasRkTagOption returns true if option is one of comma separated values in rk tag of field.
+func hasRkTagOption(field reflect.StructField, option string) bool {
+	for _, opt := range strings.Split(field.Tag.Get("rk"), ",") {
+		if strings.TrimSpace(opt) == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isEmptyValue follows the definition of empty value of omitempty in encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// isBasicAuthCredential returns true if credential is base64 of <username>:<password>.
+func isBasicAuthCredential(credential string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(credential)
+	if err != nil {
+		return false
+	}
+
+	return strings.Index(string(decoded), ":") > 0
+}
+
+// luhnValid returns true if digits in number pass Luhn check, spaces and dashes are ignored.
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// NewRedactCore wraps core so that messages and fields of all logs are redacted by r.
+func NewRedactCore(core zapcore.Core, r *Redactor) zapcore.Core {
+	return &redactCore{
+		Core:     core,
+		redactor: r,
+	}
+}
+
+// WithRedactor returns zap.Option which wraps core of logger with NewRedactCore.
+func WithRedactor(r *Redactor) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactCore(core, r)
+	})
+}
+
+type redactCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+// With redacts fields before adding them to core.
+func (c *redactCore) With(fields []zap.Field) zapcore.Core {
+	return &redactCore{
+		Core:     c.Core.With(c.redactor.RedactFields(fields)),
+		redactor: c.redactor,
+	}
+}
+
+// Check checks entry with wrapped core, entry is redacted before written into cores decided by wrapped core.
+func (c *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checkWrapped(c.Core, entry, checked,
+		func(inner *zapcore.CheckedEntry, entry zapcore.Entry, fields []zap.Field) error {
+			entry.Message = c.redactor.RedactString(entry.Message)
+			return writeChecked(inner, entry, c.redactor.RedactFields(fields))
+		})
+}
+
+// Write redacts message and fields before writing them into core.
+func (c *redactCore) Write(entry zapcore.Entry, fields []zap.Field) error {
+	entry.Message = c.redactor.RedactString(entry.Message)
+	return c.Core.Write(entry, c.redactor.RedactFields(fields))
+}
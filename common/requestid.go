@@ -0,0 +1,399 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RequestIdTypeUUIDv4 generates random UUID, this is the default type
+	RequestIdTypeUUIDv4 = "uuidv4"
+	// RequestIdTypeUUIDv7 generates time-ordered UUID defined in RFC 9562
+	RequestIdTypeUUIDv7 = "uuidv7"
+	// RequestIdTypeULID generates 26 characters ULID, monotonic within the same millisecond
+	RequestIdTypeULID = "ulid"
+	// RequestIdTypeKSUID generates 27 characters KSUID, ordered by second
+	RequestIdTypeKSUID = "ksuid"
+	// RequestIdTypeSnowflake generates 64 bits snowflake ID in decimal
+	RequestIdTypeSnowflake = "snowflake"
+
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	ksuidEpoch        = 1400000000
+
+	// MaxRequestIdLength is the max length of request id accepted by ParseRequestId
+	MaxRequestIdLength = 128
+
+	// requestIdMaxClockSkew is the max duration of embedded time after now accepted by ParseRequestId
+	requestIdMaxClockSkew = 24 * time.Hour
+)
+
+var (
+	requestIdCharsetRegex   = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	requestIdUUIDRegex      = regexp.MustCompile(`^(?:(.+)-)?([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-([0-9a-fA-F])[0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12})$`)
+	requestIdULIDRegex      = regexp.MustCompile(`^(?:(.+)-)?([0-7][0-9A-HJKMNP-TV-Z]{25})$`)
+	requestIdKSUIDRegex     = regexp.MustCompile(`^(?:(.+)-)?([0-9A-Za-z]{27})$`)
+	// snowflake id generated after 2015 has at least 18 digits
+	requestIdSnowflakeRegex = regexp.MustCompile(`^(?:(.+)-)?([1-9][0-9]{17,18})$`)
+
+	// requestIdMinTime is the min embedded time accepted by ParseRequestId, earlier time implies that request id
+	// was not generated by RequestIdGenerator
+	requestIdMinTime = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+var (
+	requestIdGeneratorLock sync.RWMutex
+	requestIdGenerator     RequestIdGenerator = &uuidV4Generator{}
+)
+
+// RequestIdGenerator generates request ids.
+type RequestIdGenerator interface {
+	// Generate returns a new id, error would be returned if random source or clock failed
+	Generate() (string, error)
+}
+
+// RequestIdConfig is a YAML decodable config of request id generator in boot config.
+//
+// Example:
+// ---
+// type: snowflake
+// snowflake:
+//   workerId: 1
+type RequestIdConfig struct {
+	// Type of generator, could be uuidv4, uuidv7, ulid, ksuid and snowflake, uuidv4 would be used if empty
+	Type string `yaml:"type" json:"type"`
+	// Snowflake config which is used if type is snowflake
+	Snowflake *SnowflakeConfig `yaml:"snowflake" json:"snowflake"`
+}
+
+// NewRequestIdGenerator returns generator with config, UUIDv4 generator would be returned if config is nil.
+func NewRequestIdGenerator(config *RequestIdConfig) (RequestIdGenerator, error) {
+	if config == nil {
+		config = &RequestIdConfig{}
+	}
+
+	switch strings.ToLower(config.Type) {
+	case "", RequestIdTypeUUIDv4:
+		return &uuidV4Generator{}, nil
+	case RequestIdTypeUUIDv7:
+		return &uuidV7Generator{}, nil
+	case RequestIdTypeULID:
+		return &ulidGenerator{}, nil
+	case RequestIdTypeKSUID:
+		return &ksuidGenerator{}, nil
+	case RequestIdTypeSnowflake:
+		return NewSnowflakeGenerator(config.Snowflake)
+	}
+
+	return nil, fmt.Errorf("unknown request id type %q, available types are %s", config.Type, strings.Join([]string{
+		RequestIdTypeUUIDv4, RequestIdTypeUUIDv7, RequestIdTypeULID, RequestIdTypeKSUID, RequestIdTypeSnowflake,
+	}, ", "))
+}
+
+// SetRequestIdGenerator replaces generator used by GenerateRequestId and GenerateRequestIdWithPrefix.
+func SetRequestIdGenerator(generator RequestIdGenerator) {
+	if generator == nil {
+		return
+	}
+
+	requestIdGeneratorLock.Lock()
+	defer requestIdGeneratorLock.Unlock()
+	requestIdGenerator = generator
+}
+
+// GetRequestIdGenerator returns generator used by GenerateRequestId and GenerateRequestIdWithPrefix.
+func GetRequestIdGenerator() RequestIdGenerator {
+	requestIdGeneratorLock.RLock()
+	defer requestIdGeneratorLock.RUnlock()
+	return requestIdGenerator
+}
+
+// ConfigureRequestIdGenerator creates generator with config and sets it with SetRequestIdGenerator.
+func ConfigureRequestIdGenerator(config *RequestIdConfig) error {
+	generator, err := NewRequestIdGenerator(config)
+	if err != nil {
+		return err
+	}
+
+	SetRequestIdGenerator(generator)
+	return nil
+}
+
+// RequestIdInfo is the parsed request id.
+type RequestIdInfo struct {
+	// Prefix of request id generated by GenerateRequestIdWithPrefix, empty if absent
+	Prefix string
+	// Id is the request id without prefix
+	Id string
+	// Type is one of RequestIdType*, empty if format is not recognized
+	Type string
+	// Time is the timestamp embedded in uuidv7, ulid, ksuid and snowflake, zero for other types
+	Time time.Time
+}
+
+// ParseRequestId parses request id generated by RequestIdGenerator, with or without prefix.
+//
+// Error would be returned if request id is empty, longer than MaxRequestIdLength or contains characters other than
+// letters, digits, '.', '_' and '-'. Request id with valid charset but unknown format is returned with empty Type.
+//
+// Types with embedded time are recognized only if time is between 2015 and one day after now, so that arbitrary
+// numbers and strings like "order-123" would not be recognized as snowflake or KSUID.
+func ParseRequestId(requestId string) (*RequestIdInfo, error) {
+	if len(requestId) < 1 || len(requestId) > MaxRequestIdLength {
+		return nil, fmt.Errorf("length of request id should be in range [1, %d]", MaxRequestIdLength)
+	}
+
+	if !requestIdCharsetRegex.MatchString(requestId) {
+		return nil, fmt.Errorf("invalid characters in request id %q", requestId)
+	}
+
+	if tokens := requestIdUUIDRegex.FindStringSubmatch(requestId); tokens != nil {
+		switch tokens[3] {
+		case "4":
+			return &RequestIdInfo{Prefix: tokens[1], Id: tokens[2], Type: RequestIdTypeUUIDv4}, nil
+		case "7":
+			id, _ := uuid.Parse(tokens[2])
+			ms := int64(id[0])<<40 | int64(id[1])<<32 | int64(id[2])<<24 | int64(id[3])<<16 | int64(id[4])<<8 | int64(id[5])
+			if t := msToTime(ms); isPlausibleRequestIdTime(t) {
+				return &RequestIdInfo{Prefix: tokens[1], Id: tokens[2], Type: RequestIdTypeUUIDv7, Time: t}, nil
+			}
+		}
+	}
+
+	if tokens := requestIdULIDRegex.FindStringSubmatch(requestId); tokens != nil {
+		var ms int64
+		for i := 0; i < 10; i++ {
+			ms = ms<<5 | int64(strings.IndexByte(crockfordAlphabet, tokens[2][i]))
+		}
+		if t := msToTime(ms); isPlausibleRequestIdTime(t) {
+			return &RequestIdInfo{Prefix: tokens[1], Id: tokens[2], Type: RequestIdTypeULID, Time: t}, nil
+		}
+	}
+
+	if tokens := requestIdKSUIDRegex.FindStringSubmatch(requestId); tokens != nil {
+		if id, ok := decodeBase62(tokens[2], 20); ok {
+			t := time.Unix(int64(binary.BigEndian.Uint32(id[:4]))+ksuidEpoch, 0)
+			if isPlausibleRequestIdTime(t) {
+				return &RequestIdInfo{Prefix: tokens[1], Id: tokens[2], Type: RequestIdTypeKSUID, Time: t}, nil
+			}
+		}
+	}
+
+	if tokens := requestIdSnowflakeRegex.FindStringSubmatch(requestId); tokens != nil {
+		if id, err := ParseSnowflakeId(tokens[2]); err == nil && isPlausibleRequestIdTime(id.Time) {
+			return &RequestIdInfo{Prefix: tokens[1], Id: tokens[2], Type: RequestIdTypeSnowflake, Time: id.Time}, nil
+		}
+	}
+
+	return &RequestIdInfo{Id: requestId}, nil
+}
+
+// ValidateRequestId returns error if request id is not valid for ParseRequestId.
+// If types were provided, request id must be one of them.
+func ValidateRequestId(requestId string, types ...string) error {
+	info, err := ParseRequestId(requestId)
+	if err != nil {
+		return err
+	}
+
+	if len(types) < 1 {
+		return nil
+	}
+
+	for i := range types {
+		if len(info.Type) > 0 && strings.EqualFold(types[i], info.Type) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("request id %q is not one of types %s", requestId, strings.Join(types, ", "))
+}
+
+// isPlausibleRequestIdTime returns true if t is between requestIdMinTime and requestIdMaxClockSkew after now.
+func isPlausibleRequestIdTime(t time.Time) bool {
+	return !t.Before(requestIdMinTime) && t.Before(time.Now().Add(requestIdMaxClockSkew))
+}
+
+// msToTime converts unix milliseconds into time.Time.
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// uuidV4Generator generates random UUID with google/uuid.
+type uuidV4Generator struct{}
+
+// Generate returns UUIDv4 like 7d444840-9dc0-11d1-b245-5ffdce74fad2.
+func (g *uuidV4Generator) Generate() (string, error) {
+	// do not use uuid.New() since it would panic if any error occurs
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// uuidV7Generator generates UUIDv7 with 48 bits unix milliseconds followed by random bits.
+type uuidV7Generator struct{}
+
+// Generate returns UUIDv7 like 017f22e2-79b0-7cc3-98c4-dc0c0c07398f.
+func (g *uuidV7Generator) Generate() (string, error) {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+
+	// version 7 and variant 10
+	id[6] = (id[6] & 0x0f) | 0x70
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return id.String(), nil
+}
+
+// ulidGenerator generates ULID, random part is incremented within the same millisecond to keep order.
+type ulidGenerator struct {
+	lock   sync.Mutex
+	lastMs uint64
+	last   [10]byte
+}
+
+// Generate returns ULID like 01ARZ3NDEKTSV4RRFFQ69G5FAV.
+func (g *ulidGenerator) Generate() (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if ms <= g.lastMs {
+		// increment random part as a big endian number
+		i := len(g.last) - 1
+		for ; i >= 0; i-- {
+			g.last[i]++
+			if g.last[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			return "", errors.New("ulid random part overflow within the same millisecond")
+		}
+		ms = g.lastMs
+	} else {
+		if _, err := rand.Read(g.last[:]); err != nil {
+			return "", err
+		}
+		g.lastMs = ms
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	copy(id[6:], g.last[:])
+
+	return encodeCrockford(id), nil
+}
+
+// encodeCrockford encodes 128 bits into 26 characters of Crockford's base32.
+func encodeCrockford(id [16]byte) string {
+	res := make([]byte, 26)
+	// 130 bits are needed for 26 characters, the leading 2 bits are zero
+	var acc uint64
+	bits := 2
+	pos := 0
+	for _, b := range id {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			res[pos] = crockfordAlphabet[(acc>>uint(bits))&0x1f]
+			pos++
+		}
+	}
+
+	return string(res)
+}
+
+// ksuidGenerator generates KSUID with 32 bits seconds since 2014-05-13 followed by 128 random bits.
+type ksuidGenerator struct{}
+
+// Generate returns KSUID like 0ujtsYcgvSTl8PAuAdqWYSMnLOv.
+func (g *ksuidGenerator) Generate() (string, error) {
+	var id [20]byte
+	if _, err := rand.Read(id[4:]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()-ksuidEpoch))
+
+	return encodeBase62(id[:], 27), nil
+}
+
+// encodeBase62 encodes big endian number into base62 with leading zeros padded to size.
+func encodeBase62(src []byte, size int) string {
+	num := append([]byte{}, src...)
+	res := make([]byte, size)
+	for i := range res {
+		res[i] = '0'
+	}
+
+	for pos := size - 1; pos >= 0; pos-- {
+		// divide num by 62 in place
+		remainder := 0
+		allZero := true
+		for i := range num {
+			acc := remainder<<8 | int(num[i])
+			num[i] = byte(acc / 62)
+			remainder = acc % 62
+			if num[i] != 0 {
+				allZero = false
+			}
+		}
+		res[pos] = base62Alphabet[remainder]
+		if allZero {
+			break
+		}
+	}
+
+	return string(res)
+}
+
+// decodeBase62 decodes base62 string into big endian number of size bytes, false would be returned if overflow.
+func decodeBase62(src string, size int) ([]byte, bool) {
+	res := make([]byte, size)
+	for i := 0; i < len(src); i++ {
+		digit := strings.IndexByte(base62Alphabet, src[i])
+		if digit < 0 {
+			return nil, false
+		}
+
+		// multiply res by 62 and add digit in place
+		carry := digit
+		for j := size - 1; j >= 0; j-- {
+			acc := int(res[j])*62 + carry
+			res[j] = byte(acc)
+			carry = acc >> 8
+		}
+		if carry > 0 {
+			return nil, false
+		}
+	}
+
+	return res, true
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRequestIdGenerator(t *testing.T) {
+	for _, tc := range []struct {
+		config *RequestIdConfig
+		regex  string
+	}{
+		{nil, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{&RequestIdConfig{Type: "UUIDv7"}, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{&RequestIdConfig{Type: RequestIdTypeULID}, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{&RequestIdConfig{Type: RequestIdTypeKSUID}, `^[0-9A-Za-z]{27}$`},
+		{&RequestIdConfig{Type: RequestIdTypeSnowflake}, `^[0-9]{1,19}$`},
+	} {
+		generator, err := NewRequestIdGenerator(tc.config)
+		assert.Nil(t, err)
+
+		id, err := generator.Generate()
+		assert.Nil(t, err)
+		assert.Regexp(t, regexp.MustCompile(tc.regex), id)
+	}
+
+	// With invalid config
+	_, err := NewRequestIdGenerator(&RequestIdConfig{Type: "unknown"})
+	assert.NotNil(t, err)
+	_, err = NewRequestIdGenerator(&RequestIdConfig{Type: RequestIdTypeSnowflake, Snowflake: &SnowflakeConfig{WorkerId: 1024}})
+	assert.NotNil(t, err)
+}
+
+func TestRequestIdGenerator_Sortable(t *testing.T) {
+	for _, idType := range []string{RequestIdTypeUUIDv7, RequestIdTypeULID, RequestIdTypeSnowflake} {
+		generator, err := NewRequestIdGenerator(&RequestIdConfig{Type: idType})
+		assert.Nil(t, err)
+
+		ids := make([]string, 0)
+		for i := 0; i < 5000; i++ {
+			id, _ := generator.Generate()
+			ids = append(ids, id)
+		}
+
+		if idType == RequestIdTypeUUIDv7 {
+			// random bits are not monotonic within the same millisecond, compare timestamp only
+			for i := range ids {
+				ids[i] = ids[i][:13]
+			}
+		}
+
+		assert.True(t, sort.StringsAreSorted(ids), idType)
+	}
+}
+
+func TestEncodeBase62(t *testing.T) {
+	assert.Equal(t, "000", encodeBase62([]byte{0}, 3))
+	assert.Equal(t, "010", encodeBase62([]byte{62}, 3))
+	assert.Equal(t, "LygHa16AHYF", encodeBase62([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 11))
+}
+
+func TestEncodeCrockford(t *testing.T) {
+	var id [16]byte
+	assert.Equal(t, strings.Repeat("0", 26), encodeCrockford(id))
+
+	for i := range id {
+		id[i] = 0xff
+	}
+	assert.Equal(t, "7"+strings.Repeat("Z", 25), encodeCrockford(id))
+}
+
+func TestConfigureRequestIdGenerator(t *testing.T) {
+	defer SetRequestIdGenerator(&uuidV4Generator{})
+
+	config := &RequestIdConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`type: ulid`), config))
+	assert.Nil(t, ConfigureRequestIdGenerator(config))
+	assert.Len(t, GenerateRequestId(), 26)
+	assert.True(t, strings.HasPrefix(GenerateRequestIdWithPrefix("ut"), "ut-"))
+
+	// generator would not be replaced with invalid config or nil
+	assert.NotNil(t, ConfigureRequestIdGenerator(&RequestIdConfig{Type: "unknown"}))
+	SetRequestIdGenerator(nil)
+	assert.Len(t, GenerateRequestId(), 26)
+
+	SetRequestIdGenerator(&uuidV4Generator{})
+	_, err := uuid.Parse(GenerateRequestId())
+	assert.Nil(t, err)
+}
+
+func TestParseRequestId(t *testing.T) {
+	for _, idType := range []string{RequestIdTypeUUIDv4, RequestIdTypeUUIDv7, RequestIdTypeULID, RequestIdTypeKSUID, RequestIdTypeSnowflake} {
+		generator, err := NewRequestIdGenerator(&RequestIdConfig{Type: idType})
+		assert.Nil(t, err)
+
+		before := time.Now().Add(-time.Second)
+		id, err := generator.Generate()
+		assert.Nil(t, err)
+
+		for _, prefix := range []string{"", "my-service"} {
+			requestId := id
+			if len(prefix) > 0 {
+				requestId = prefix + "-" + id
+			}
+
+			info, err := ParseRequestId(requestId)
+			assert.Nil(t, err)
+			assert.Equal(t, prefix, info.Prefix, idType)
+			assert.Equal(t, id, info.Id)
+			assert.Equal(t, idType, info.Type)
+			if idType == RequestIdTypeUUIDv4 {
+				assert.True(t, info.Time.IsZero())
+			} else {
+				assert.True(t, info.Time.After(before) && info.Time.Before(time.Now().Add(time.Second)), idType)
+			}
+		}
+	}
+
+	// unknown format
+	info, err := ParseRequestId("ut-id")
+	assert.Nil(t, err)
+	assert.Equal(t, "ut-id", info.Id)
+	assert.Empty(t, info.Type)
+
+	// KSUID overflows 160 bits
+	info, err = ParseRequestId("zzzzzzzzzzzzzzzzzzzzzzzzzzz")
+	assert.Nil(t, err)
+	assert.Empty(t, info.Type)
+
+	// numbers and strings with implausible time are not recognized
+	for _, id := range []string{
+		"42",
+		"order-123",
+		"123456789012345678",
+		"9223372036854775807",
+		"000000000000000000000000000",
+		"Aaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"7ZZZZZZZZZZZZZZZZZZZZZZZZZ",
+		"00000000000000000000000000",
+		"00000000-0000-7000-8000-000000000000",
+	} {
+		info, err = ParseRequestId(id)
+		assert.Nil(t, err)
+		assert.Empty(t, info.Type, id)
+	}
+
+	// With invalid request id
+	for _, invalid := range []string{"", "a b", "<script>", "id\n", strings.Repeat("a", MaxRequestIdLength+1)} {
+		_, err = ParseRequestId(invalid)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestValidateRequestId(t *testing.T) {
+	assert.Nil(t, ValidateRequestId("ut-id"))
+	assert.NotNil(t, ValidateRequestId("ut id"))
+
+	generator, _ := NewRequestIdGenerator(&RequestIdConfig{Type: RequestIdTypeULID})
+	id, _ := generator.Generate()
+	assert.Nil(t, ValidateRequestId(id, RequestIdTypeUUIDv7, "ULID"))
+	assert.NotNil(t, ValidateRequestId(id, RequestIdTypeUUIDv4))
+	assert.NotNil(t, ValidateRequestId("ut-id", RequestIdTypeUUIDv4))
+}
+
+func TestDecodeBase62(t *testing.T) {
+	res, ok := decodeBase62("LygHa16AHYF", 8)
+	assert.True(t, ok)
+	assert.Equal(t, "LygHa16AHYF", encodeBase62(res, 11))
+
+	_, ok = decodeBase62("LygHa16AHYG", 8)
+	assert.False(t, ok)
+	_, ok = decodeBase62("!", 8)
+	assert.False(t, ok)
+}
@@ -0,0 +1,529 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	megabyte            = 1024 * 1024
+	rotateBackupTimeFmt = "2006-01-02T15-04-05.000"
+	rotateBackupRegex   = `\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3}`
+	compressSuffix      = ".gz"
+)
+
+// RotatingWriterConfig is the config of RotatingWriter.
+//
+// The first six fields have the same YAML keys and meaning as lumberjack.Logger, the rest extend it with time based
+// rotation and disk quota.
+//
+// Example:
+// ---
+// filename: logs/app.log
+// maxsize: 1024
+// maxbackups: 30
+// compress: true
+// rotateEvery: daily
+// filenamePattern: app-%Y%m%d.log
+// maxTotalSize: 10240
+type RotatingWriterConfig struct {
+	// Filename is the file to write logs to, directory of it would be used if FilenamePattern was provided
+	Filename string `yaml:"filename" json:"filename" mapstructure:"filename"`
+	// MaxSize is the maximum size in megabytes of the file before it gets rotated, 0 disables size based rotation
+	MaxSize int `yaml:"maxsize" json:"maxsize" mapstructure:"maxsize"`
+	// MaxAge is the maximum number of days to retain old files based on modification time, 0 keeps all of them
+	MaxAge int `yaml:"maxage" json:"maxage" mapstructure:"maxage"`
+	// MaxBackups is the maximum number of old files to retain, 0 keeps all of them
+	MaxBackups int `yaml:"maxbackups" json:"maxbackups" mapstructure:"maxbackups"`
+	// LocalTime determines if local time is used for rotation period and file names, UTC is used by default
+	LocalTime bool `yaml:"localtime" json:"localtime" mapstructure:"localtime"`
+	// Compress determines if old files should be compressed with gzip
+	Compress bool `yaml:"compress" json:"compress" mapstructure:"compress"`
+	// RotateEvery is the period of time based rotation, could be hourly, daily or duration like 30m and 12h
+	RotateEvery string `yaml:"rotateEvery" json:"rotateEvery" mapstructure:"rotateEvery"`
+	// FilenamePattern is the name of file built from start time of current period, which is relative to directory
+	// of Filename. %Y, %m, %d, %H, %M, %S and %% are supported, like app-%Y%m%d.log. Tokens of time are not allowed
+	// in directory part of pattern since old files are cleaned up in a single directory.
+	FilenamePattern string `yaml:"filenamePattern" json:"filenamePattern" mapstructure:"filenamePattern"`
+	// MaxTotalSize is the maximum size in megabytes of current file and all old files, 0 disables the quota
+	MaxTotalSize int `yaml:"maxTotalSize" json:"maxTotalSize" mapstructure:"maxTotalSize"`
+}
+
+// RotatingWriter is an io.WriteCloser which rotates files based on both size and time.
+//
+// Old files are named as <name>-<timestamp><ext>, like lumberjack does. Cleanup and compression of old files run
+// in background after every rotation.
+type RotatingWriter struct {
+	config  RotatingWriterConfig
+	every   time.Duration
+	dir     string
+	matcher *regexp.Regexp
+	now     func() time.Time
+
+	lock       sync.Mutex
+	file       *os.File
+	name       string
+	size       int64
+	nextRotate time.Time
+	closed     bool
+
+	millLock sync.Mutex
+	millCh   chan struct{}
+	millWg   sync.WaitGroup
+}
+
+// NewRotatingWriter validates config and returns RotatingWriter, file would be opened at the first write.
+func NewRotatingWriter(config *RotatingWriterConfig) (*RotatingWriter, error) {
+	if config == nil || len(config.Filename) < 1 {
+		return nil, errors.New("empty file name of rotating writer")
+	}
+
+	if config.MaxSize < 0 || config.MaxAge < 0 || config.MaxBackups < 0 || config.MaxTotalSize < 0 {
+		return nil, errors.New("negative maxsize, maxage, maxbackups or maxTotalSize not allowed")
+	}
+
+	every, err := parseRotateEvery(config.RotateEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := filepath.Abs(config.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// name of current file and old files share the same prefix and extension
+	dir, base := filepath.Split(filename)
+	namePattern := regexp.QuoteMeta(base)
+	if len(config.FilenamePattern) > 0 {
+		if every <= 0 {
+			return nil, errors.New("filenamePattern requires rotateEvery")
+		}
+		patternDir := filepath.Dir(config.FilenamePattern)
+		if hasTimeToken(patternDir) {
+			return nil, fmt.Errorf("invalid filenamePattern %q: time tokens in directory %q not allowed",
+				config.FilenamePattern, patternDir)
+		}
+		dir = filepath.Join(dir, formatPattern(patternDir, time.Time{}))
+		base = filepath.Base(config.FilenamePattern)
+		namePattern = patternToRegex(base)
+	}
+	ext := filepath.Ext(base)
+	namePattern = strings.TrimSuffix(namePattern, regexp.QuoteMeta(ext))
+
+	matcher, err := regexp.Compile(fmt.Sprintf(`^%s(-%s)?%s(%s)?$`,
+		namePattern, rotateBackupRegex, regexp.QuoteMeta(ext), regexp.QuoteMeta(compressSuffix)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid filenamePattern %q: %v", config.FilenamePattern, err)
+	}
+
+	w := &RotatingWriter{
+		config:  *config,
+		every:   every,
+		dir:     dir,
+		matcher: matcher,
+		now:     time.Now,
+		millCh:  make(chan struct{}, 1),
+	}
+	w.config.Filename = filename
+
+	w.millWg.Add(1)
+	go w.millRun()
+
+	return w, nil
+}
+
+// Write writes p into current file, rotates it first if period was over or size would exceed MaxSize.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	now := w.currentTime()
+	if w.file == nil {
+		if err := w.openExistingOrNew(now); err != nil {
+			return 0, err
+		}
+	}
+
+	if (w.every > 0 && !now.Before(w.nextRotate)) ||
+		(w.config.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > int64(w.config.MaxSize)*megabyte) {
+		if err := w.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Sync commits current file to stable storage.
+func (w *RotatingWriter) Sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Sync()
+}
+
+// Rotate closes current file and opens a new one, old file would be renamed if the name would not change.
+func (w *RotatingWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	return w.rotate(w.currentTime())
+}
+
+// Close closes current file and waits for running cleanup of old files.
+func (w *RotatingWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	err := w.closeFile()
+	close(w.millCh)
+	w.lock.Unlock()
+
+	w.millWg.Wait()
+	return err
+}
+
+func (w *RotatingWriter) currentTime() time.Time {
+	if w.config.LocalTime {
+		return w.now().Local()
+	}
+
+	return w.now().UTC()
+}
+
+// periodStart returns start time of the period which t belongs to, period is aligned with time zone of t.
+func (w *RotatingWriter) periodStart(t time.Time) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(w.every).Add(-shift)
+}
+
+// activeName returns the path of file which should be written at t.
+func (w *RotatingWriter) activeName(t time.Time) string {
+	if len(w.config.FilenamePattern) < 1 {
+		return w.config.Filename
+	}
+
+	return filepath.Join(w.dir, formatPattern(filepath.Base(w.config.FilenamePattern), w.periodStart(t)))
+}
+
+func (w *RotatingWriter) openExistingOrNew(now time.Time) error {
+	name := w.activeName(now)
+	info, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return w.openNew(now)
+		}
+		return err
+	}
+
+	// file written in previous period should be rotated first
+	if w.every > 0 && info.ModTime().Before(w.periodStart(now)) {
+		w.name = name
+		return w.rotate(now)
+	}
+
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return w.openNew(now)
+	}
+
+	w.file = file
+	w.name = name
+	w.size = info.Size()
+	w.updateNextRotate(now)
+	return nil
+}
+
+func (w *RotatingWriter) openNew(now time.Time) error {
+	name := w.activeName(now)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.name = name
+	w.size = 0
+	w.updateNextRotate(now)
+	w.mill()
+	return nil
+}
+
+func (w *RotatingWriter) rotate(now time.Time) error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	// keep the old file with timestamp if the new file would have the same name
+	if len(w.name) > 0 && w.name == w.activeName(now) {
+		if _, err := os.Stat(w.name); err == nil {
+			ext := filepath.Ext(w.name)
+			backup := strings.TrimSuffix(w.name, ext) + "-" + now.Format(rotateBackupTimeFmt) + ext
+			if err := os.Rename(w.name, backup); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.openNew(now)
+}
+
+func (w *RotatingWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *RotatingWriter) updateNextRotate(now time.Time) {
+	if w.every > 0 {
+		w.nextRotate = w.periodStart(now).Add(w.every)
+	}
+}
+
+// mill triggers cleanup of old files without blocking.
+func (w *RotatingWriter) mill() {
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *RotatingWriter) millRun() {
+	defer w.millWg.Done()
+
+	for range w.millCh {
+		// errors are ignored since there is no place to report them
+		w.millRunOnce()
+	}
+}
+
+// millRunOnce removes old files exceeding MaxBackups, MaxAge or MaxTotalSize and compresses the rest.
+func (w *RotatingWriter) millRunOnce() error {
+	w.millLock.Lock()
+	defer w.millLock.Unlock()
+
+	w.lock.Lock()
+	active := w.name
+	now := w.now()
+	w.lock.Unlock()
+
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	olds := make([]os.FileInfo, 0)
+	var total int64
+	for _, f := range files {
+		if f.IsDir() || !w.matcher.MatchString(f.Name()) {
+			continue
+		}
+
+		if filepath.Join(w.dir, f.Name()) == active {
+			total += f.Size()
+			continue
+		}
+		olds = append(olds, f)
+	}
+
+	// newest first
+	sort.Slice(olds, func(i, j int) bool {
+		if olds[i].ModTime().Equal(olds[j].ModTime()) {
+			return olds[i].Name() > olds[j].Name()
+		}
+		return olds[i].ModTime().After(olds[j].ModTime())
+	})
+
+	cutoff := now.Add(-time.Duration(w.config.MaxAge) * 24 * time.Hour)
+	for i, f := range olds {
+		total += f.Size()
+		path := filepath.Join(w.dir, f.Name())
+
+		if (w.config.MaxBackups > 0 && i >= w.config.MaxBackups) ||
+			(w.config.MaxAge > 0 && f.ModTime().Before(cutoff)) ||
+			(w.config.MaxTotalSize > 0 && total > int64(w.config.MaxTotalSize)*megabyte) {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if w.config.Compress && !strings.HasSuffix(f.Name(), compressSuffix) {
+			if err := compressFile(path, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compressFile compresses file with gzip and removes the original one, modification time is kept.
+func compressFile(path string, info os.FileInfo) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressSuffix)
+		return err
+	}
+
+	if err := os.Chtimes(path+compressSuffix, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// parseRotateEvery parses hourly, daily or duration string.
+func parseRotateEvery(every string) (time.Duration, error) {
+	switch strings.ToLower(every) {
+	case "":
+		return 0, nil
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(every)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rotateEvery %q: %v", every, err)
+	}
+
+	if d < time.Second {
+		return 0, fmt.Errorf("rotateEvery %q should not be less than 1s", every)
+	}
+
+	return d, nil
+}
+
+// formatPattern replaces %Y, %m, %d, %H, %M, %S and %% in pattern with t.
+func formatPattern(pattern string, t time.Time) string {
+	return patternReplacer(func(token byte) string {
+		switch token {
+		case 'Y':
+			return t.Format("2006")
+		case 'm':
+			return t.Format("01")
+		case 'd':
+			return t.Format("02")
+		case 'H':
+			return t.Format("15")
+		case 'M':
+			return t.Format("04")
+		case 'S':
+			return t.Format("05")
+		}
+		return ""
+	}, func(literal string) string {
+		return literal
+	}, pattern)
+}
+
+// hasTimeToken returns true if pattern contains any of %Y, %m, %d, %H, %M and %S.
+func hasTimeToken(pattern string) bool {
+	found := false
+	patternReplacer(func(byte) string {
+		found = true
+		return ""
+	}, func(literal string) string {
+		return literal
+	}, pattern)
+
+	return found
+}
+
+// patternToRegex converts pattern into regular expression which matches any time.
+func patternToRegex(pattern string) string {
+	return patternReplacer(func(token byte) string {
+		if token == 'Y' {
+			return `\d{4}`
+		}
+		return `\d{2}`
+	}, regexp.QuoteMeta, pattern)
+}
+
+func patternReplacer(token func(byte) string, literal func(string) string, pattern string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+
+		switch next := pattern[i+1]; next {
+		case 'Y', 'm', 'd', 'H', 'M', 'S':
+			b.WriteString(literal(pattern[start:i]))
+			b.WriteString(token(next))
+		case '%':
+			b.WriteString(literal(pattern[start:i] + "%"))
+		default:
+			continue
+		}
+
+		i++
+		start = i + 1
+	}
+	b.WriteString(literal(pattern[start:]))
+
+	return b.String()
+}
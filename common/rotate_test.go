@@ -0,0 +1,301 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestRotatingWriter(t *testing.T, config *RotatingWriterConfig, now time.Time) (*RotatingWriter, *testClock) {
+	w, err := NewRotatingWriter(config)
+	assert.Nil(t, err)
+
+	clock := &testClock{now: now}
+	w.now = clock.Now
+
+	return w, clock
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+
+	res := make([]string, 0)
+	for _, f := range files {
+		res = append(res, f.Name())
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func TestNewRotatingWriter_WithInvalidConfig(t *testing.T) {
+	for _, config := range []*RotatingWriterConfig{
+		nil,
+		{},
+		{Filename: "app.log", MaxSize: -1},
+		{Filename: "app.log", MaxTotalSize: -1},
+		{Filename: "app.log", RotateEvery: "weekly"},
+		{Filename: "app.log", RotateEvery: "1ms"},
+		{Filename: "app.log", FilenamePattern: "app-%Y.log"},
+	} {
+		_, err := NewRotatingWriter(config)
+		assert.NotNil(t, err)
+	}
+
+	// Time tokens in directory part of pattern
+	_, err := NewRotatingWriter(&RotatingWriterConfig{
+		Filename:        "app.log",
+		RotateEvery:     "daily",
+		FilenamePattern: "logs/%Y/%m/app.log",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "time tokens in directory")
+}
+
+func TestRotatingWriter_RotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	w, clock := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename: path.Join(dir, "app.log"),
+		MaxSize:  1,
+	}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err := w.Write([]byte(strings.Repeat("a", megabyte-1)))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app.log"}, listFiles(t, dir))
+
+	clock.Add(time.Second)
+	_, err = w.Write([]byte("c"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"app-2021-01-01T00-00-01.000.log", "app.log"}, listFiles(t, dir))
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, "app.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "c", string(bytes))
+}
+
+func TestRotatingWriter_RotateEvery(t *testing.T) {
+	dir := t.TempDir()
+	w, clock := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename:    path.Join(dir, "app.log"),
+		RotateEvery: "hourly",
+	}, time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC))
+
+	_, err := w.Write([]byte("a"))
+	assert.Nil(t, err)
+
+	// Same period
+	clock.Add(29 * time.Minute)
+	_, err = w.Write([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app.log"}, listFiles(t, dir))
+
+	// Next period
+	clock.Add(time.Minute)
+	_, err = w.Write([]byte("c"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"app-2021-01-01T11-00-00.000.log", "app.log"}, listFiles(t, dir))
+}
+
+func TestRotatingWriter_WithFilenamePattern(t *testing.T) {
+	dir := t.TempDir()
+	w, clock := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename:        path.Join(dir, "app.log"),
+		RotateEvery:     "daily",
+		FilenamePattern: "app-%Y%m%d.log",
+		MaxSize:         1,
+	}, time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC))
+
+	_, err := w.Write([]byte("a"))
+	assert.Nil(t, err)
+
+	clock.Add(time.Hour)
+	_, err = w.Write([]byte(strings.Repeat("b", megabyte)))
+	assert.Nil(t, err)
+
+	// Rotated by size within the same period
+	_, err = w.Write([]byte("c"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{
+		"app-20210101.log",
+		"app-20210102-2021-01-02T00-00-00.000.log",
+		"app-20210102.log",
+	}, listFiles(t, dir))
+}
+
+func TestRotatingWriter_WithFilenamePatternInSubDir(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename:        path.Join(dir, "app.log"),
+		RotateEvery:     "daily",
+		FilenamePattern: "logs/app-%Y%m%d.log",
+	}, time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC))
+
+	_, err := w.Write([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"app-20210101.log"}, listFiles(t, path.Join(dir, "logs")))
+}
+
+func TestRotatingWriter_WithExistingFileOfPreviousPeriod(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "app.log"), []byte("old"), 0644))
+
+	w, _ := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename:    path.Join(dir, "app.log"),
+		RotateEvery: "24h",
+	}, time.Now().Add(24*time.Hour))
+
+	_, err := w.Write([]byte("new"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	files := listFiles(t, dir)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "app.log", files[1])
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, "app.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(bytes))
+}
+
+func TestRotatingWriter_WithRetention(t *testing.T) {
+	dir := t.TempDir()
+	w, clock := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename:     path.Join(dir, "app.log"),
+		MaxBackups:   3,
+		MaxTotalSize: 1,
+	}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// Unrelated file should be kept
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "other.log"), []byte("other"), 0644))
+
+	for i := 0; i < 5; i++ {
+		_, err := w.Write([]byte(strings.Repeat("a", megabyte/2)))
+		assert.Nil(t, err)
+		clock.Add(time.Second)
+		assert.Nil(t, w.Rotate())
+		assert.Nil(t, w.millRunOnce())
+	}
+	assert.Nil(t, w.Close())
+
+	// Total size of active file and two backups reaches 1MB
+	assert.Equal(t, []string{
+		"app-2021-01-01T00-00-04.000.log",
+		"app-2021-01-01T00-00-05.000.log",
+		"app.log",
+		"other.log",
+	}, listFiles(t, dir))
+}
+
+func TestRotatingWriter_WithCompress(t *testing.T) {
+	dir := t.TempDir()
+	w, clock := newTestRotatingWriter(t, &RotatingWriterConfig{
+		Filename: path.Join(dir, "app.log"),
+		Compress: true,
+	}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err := w.Write([]byte("a"))
+	assert.Nil(t, err)
+	clock.Add(time.Second)
+	assert.Nil(t, w.Rotate())
+	assert.Nil(t, w.millRunOnce())
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"app-2021-01-01T00-00-01.000.log.gz", "app.log"}, listFiles(t, dir))
+}
+
+func TestRotatingWriter_WriteAfterClose(t *testing.T) {
+	w, err := NewRotatingWriter(&RotatingWriterConfig{Filename: path.Join(t.TempDir(), "app.log")})
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Nil(t, w.Close())
+
+	_, err = w.Write([]byte("a"))
+	assert.NotNil(t, err)
+	assert.NotNil(t, w.Rotate())
+}
+
+func TestFormatPattern(t *testing.T) {
+	now := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	assert.Equal(t, "app-20210203-040506-%-%x.log", formatPattern("app-%Y%m%d-%H%M%S-%%-%x.log", now))
+	assert.Equal(t, `app-\d{4}\d{2}%\.log`, patternToRegex("app-%Y%m%%.log"))
+}
+
+func TestNewZapLoggerFromConfig_WithRotation(t *testing.T) {
+	dir := t.TempDir()
+	config := &ZapLoggerConfig{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+zap:
+  outputPaths: ["`+path.Join(dir, "app.log")+`"]
+rotation:
+  rotateEvery: daily
+  filenamePattern: app-%Y%m%d.log
+  maxTotalSize: 1024
+`), config))
+
+	logger, err := NewZapLoggerFromConfig(config)
+	assert.Nil(t, err)
+
+	sink := fileSinks[path.Join(dir, "app.log")]
+	assert.NotNil(t, sink)
+	assert.Equal(t, 1024, sink.writer.(*RotatingWriter).config.MaxTotalSize)
+
+	logger.Info("ut-message")
+	assert.Nil(t, logger.Sync())
+	assert.Nil(t, sink.Close())
+
+	files := listFiles(t, dir)
+	assert.Len(t, files, 1)
+	assert.Regexp(t, `^app-\d{8}\.log$`, files[0])
+
+	bytes, err := ioutil.ReadFile(path.Join(dir, files[0]))
+	assert.Nil(t, err)
+	assert.Contains(t, string(bytes), "ut-message")
+}
+
+func TestNewRotatingSink_WithInvalidQuery(t *testing.T) {
+	_, err := newRotatingSink(&url.URL{Scheme: RotatingSinkScheme, Path: "/ut/app.log", RawQuery: "maxTotalSize=x"})
+	assert.NotNil(t, err)
+
+	_, err = newRotatingSink(&url.URL{Scheme: RotatingSinkScheme, Path: "/ut/app.log", RawQuery: "rotateEvery=x"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SnowflakeWorkerIdFromConfig uses workerId in config, this is the default source
+	SnowflakeWorkerIdFromConfig = "config"
+	// SnowflakeWorkerIdFromIP uses the lower 10 bits of IPv4 returned by GetLocalIP
+	SnowflakeWorkerIdFromIP = "ip"
+	// SnowflakeWorkerIdFromHostname uses hash of hostname, which may collide in large clusters
+	SnowflakeWorkerIdFromHostname = "hostname"
+	// SnowflakeWorkerIdFromOrdinal uses ordinal of pod name like web-3 in StatefulSet, which is the hostname of pod
+	SnowflakeWorkerIdFromOrdinal = "ordinal"
+	// SnowflakeWorkerIdFromEnv uses environment variable named by workerIdEnv
+	SnowflakeWorkerIdFromEnv = "env"
+
+	// DefaultSnowflakeWorkerIdEnv is the environment variable of worker id if workerIdEnv is empty
+	DefaultSnowflakeWorkerIdEnv = "RK_SNOWFLAKE_WORKER_ID"
+	// DefaultSnowflakeMaxClockRollback is the max clock rollback to wait for if maxClockRollback is empty
+	DefaultSnowflakeMaxClockRollback = 10 * time.Millisecond
+
+	snowflakeEpochMs     = 1288834974657
+	snowflakeTimeBits    = 41
+	snowflakeWorkerBits  = 10
+	snowflakeSeqBits     = 12
+	snowflakeMaxWorkerId = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSeq      = 1<<snowflakeSeqBits - 1
+)
+
+// statefulSetPodNameRegex matches pod name of StatefulSet formed as <DNS label>-<ordinal>
+var statefulSetPodNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?-(0|[1-9][0-9]*)$`)
+
+// SnowflakeConfig is the config of snowflake generator.
+//
+// Example:
+// ---
+// workerIdFrom: hostname
+// maxClockRollback: 10ms
+type SnowflakeConfig struct {
+	// WorkerId is 10 bits id of the process, used if workerIdFrom is config or empty
+	WorkerId int64 `yaml:"workerId" json:"workerId"`
+	// WorkerIdFrom is the source of worker id, could be config, ip, hostname, ordinal and env
+	WorkerIdFrom string `yaml:"workerIdFrom" json:"workerIdFrom"`
+	// WorkerIdEnv is the environment variable of worker id if workerIdFrom is env
+	WorkerIdEnv string `yaml:"workerIdEnv" json:"workerIdEnv"`
+	// MaxClockRollback is the max duration to wait for if clock moved backwards, error would be returned for larger
+	// rollback, 0s means always return error, 10ms would be used if empty
+	MaxClockRollback string `yaml:"maxClockRollback" json:"maxClockRollback"`
+}
+
+// SnowflakeId is the decoded snowflake id.
+type SnowflakeId struct {
+	// Time is the millisecond when id was generated
+	Time time.Time
+	// WorkerId is the id of the process which generated id
+	WorkerId int64
+	// Sequence is the sequence within the millisecond
+	Sequence int64
+}
+
+// SnowflakeGenerator generates 64 bits ids composed of 41 bits milliseconds since 2010-11-04, 10 bits worker id
+// and 12 bits sequence. It is safe for concurrent use.
+type SnowflakeGenerator struct {
+	workerId         int64
+	maxClockRollback time.Duration
+	now              func() time.Time
+	sleep            func(time.Duration)
+
+	lock     sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+// NewSnowflakeGenerator returns SnowflakeGenerator, worker id would be zero if config is nil.
+func NewSnowflakeGenerator(config *SnowflakeConfig) (*SnowflakeGenerator, error) {
+	if config == nil {
+		config = &SnowflakeConfig{}
+	}
+
+	workerId, err := snowflakeWorkerId(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if workerId < 0 || workerId > snowflakeMaxWorkerId {
+		return nil, fmt.Errorf("workerId %d out of range [0, %d]", workerId, snowflakeMaxWorkerId)
+	}
+
+	maxClockRollback := DefaultSnowflakeMaxClockRollback
+	if len(config.MaxClockRollback) > 0 {
+		maxClockRollback, err = time.ParseDuration(config.MaxClockRollback)
+		if err != nil || maxClockRollback < 0 {
+			return nil, fmt.Errorf("invalid maxClockRollback %q", config.MaxClockRollback)
+		}
+	}
+
+	return &SnowflakeGenerator{
+		workerId:         workerId,
+		maxClockRollback: maxClockRollback,
+		now:              time.Now,
+		sleep:            time.Sleep,
+	}, nil
+}
+
+// snowflakeWorkerId derives worker id from source in config.
+func snowflakeWorkerId(config *SnowflakeConfig) (int64, error) {
+	switch strings.ToLower(config.WorkerIdFrom) {
+	case "", SnowflakeWorkerIdFromConfig:
+		return config.WorkerId, nil
+	case SnowflakeWorkerIdFromIP:
+		return snowflakeWorkerIdFromIP(GetLocalIP())
+	case SnowflakeWorkerIdFromHostname:
+		return snowflakeWorkerIdFromHostname(GetLocalHostname())
+	case SnowflakeWorkerIdFromOrdinal:
+		return snowflakeWorkerIdFromOrdinal(GetLocalHostname())
+	case SnowflakeWorkerIdFromEnv:
+		key := config.WorkerIdEnv
+		if len(key) < 1 {
+			key = DefaultSnowflakeWorkerIdEnv
+		}
+
+		workerId, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(key)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid worker id in environment variable %s, %v", key, err)
+		}
+		return workerId, nil
+	}
+
+	return 0, fmt.Errorf("unknown workerIdFrom %q", config.WorkerIdFrom)
+}
+
+// snowflakeWorkerIdFromIP returns the lower 10 bits of IPv4, which is unique among hosts in the same /22 subnet.
+func snowflakeWorkerIdFromIP(ip string) (int64, error) {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return 0, fmt.Errorf("failed to derive worker id from non IPv4 address %q", ip)
+	}
+
+	return int64(parsed[2]&0x03)<<8 | int64(parsed[3]), nil
+}
+
+// snowflakeWorkerIdFromHostname returns FNV-1a hash of hostname.
+// Hash may collide, so ordinal or explicit worker id should be preferred in large clusters.
+func snowflakeWorkerIdFromHostname(hostname string) (int64, error) {
+	if len(hostname) < 1 {
+		return 0, fmt.Errorf("failed to derive worker id from empty hostname")
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(hostname))
+	return int64(h.Sum32() % (snowflakeMaxWorkerId + 1)), nil
+}
+
+// snowflakeWorkerIdFromOrdinal returns ordinal of StatefulSet pod name, error would be returned if hostname is not a
+// pod name of StatefulSet or ordinal is out of range, rather than falling back to hash silently.
+func snowflakeWorkerIdFromOrdinal(hostname string) (int64, error) {
+	tokens := statefulSetPodNameRegex.FindStringSubmatch(hostname)
+	if tokens == nil {
+		return 0, fmt.Errorf("failed to derive worker id from hostname %q, not a pod name of StatefulSet", hostname)
+	}
+
+	ordinal, err := strconv.ParseInt(tokens[2], 10, 64)
+	if err != nil || ordinal > snowflakeMaxWorkerId {
+		return 0, fmt.Errorf("ordinal of hostname %q exceeds max worker id %d", hostname, snowflakeMaxWorkerId)
+	}
+
+	return ordinal, nil
+}
+
+// WorkerId returns worker id of generator.
+func (g *SnowflakeGenerator) WorkerId() int64 {
+	return g.workerId
+}
+
+// Generate returns snowflake id in decimal.
+func (g *SnowflakeGenerator) Generate() (string, error) {
+	id, err := g.NextId()
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(id, 10), nil
+}
+
+// NextId returns snowflake id.
+//
+// It waits for the next millisecond if sequence was exhausted, and waits for clock to catch up if clock moved
+// backwards within maxClockRollback, otherwise error would be returned.
+func (g *SnowflakeGenerator) NextId() (int64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	ms := g.currentMs()
+	if ms < g.lastMs {
+		rollback := time.Duration(g.lastMs-ms) * time.Millisecond
+		if rollback > g.maxClockRollback {
+			return 0, fmt.Errorf("clock moved backwards by %v, exceeds maxClockRollback %v", rollback, g.maxClockRollback)
+		}
+		ms = g.waitUntil(g.lastMs)
+	}
+
+	if ms < 0 || ms >= 1<<snowflakeTimeBits {
+		return 0, fmt.Errorf("time %v out of range of snowflake id", g.now())
+	}
+
+	if ms == g.lastMs {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSeq
+		if g.sequence == 0 {
+			ms = g.waitUntil(g.lastMs + 1)
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = ms
+
+	return ms<<(snowflakeWorkerBits+snowflakeSeqBits) | g.workerId<<snowflakeSeqBits | g.sequence, nil
+}
+
+// currentMs returns milliseconds since snowflake epoch.
+func (g *SnowflakeGenerator) currentMs() int64 {
+	return g.now().UnixNano()/int64(time.Millisecond) - snowflakeEpochMs
+}
+
+// waitUntil sleeps until milliseconds since snowflake epoch reaches target.
+func (g *SnowflakeGenerator) waitUntil(target int64) int64 {
+	ms := g.currentMs()
+	for ms < target {
+		g.sleep(time.Duration(target-ms) * time.Millisecond)
+		ms = g.currentMs()
+	}
+
+	return ms
+}
+
+// ParseSnowflakeId decodes snowflake id in decimal generated by SnowflakeGenerator.
+func ParseSnowflakeId(id string) (*SnowflakeId, error) {
+	num, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || num < 0 {
+		return nil, fmt.Errorf("invalid snowflake id %q", id)
+	}
+
+	ms := num>>(snowflakeWorkerBits+snowflakeSeqBits) + snowflakeEpochMs
+
+	return &SnowflakeId{
+		Time:     time.Unix(0, ms*int64(time.Millisecond)),
+		WorkerId: (num >> snowflakeSeqBits) & snowflakeMaxWorkerId,
+		Sequence: num & snowflakeMaxSeq,
+	}, nil
+}
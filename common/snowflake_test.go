@@ -0,0 +1,173 @@
+// Copyright (c) 2021 rookie-ninja
+//
+// Use of this source code is governed by an Apache-style
+// license that can be found in the LICENSE file.
+
+package rkcommon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSnowflakeGenerator(t *testing.T, config *SnowflakeConfig) (*SnowflakeGenerator, *testClock) {
+	generator, err := NewSnowflakeGenerator(config)
+	assert.Nil(t, err)
+
+	clock := &testClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	generator.now = clock.Now
+	generator.sleep = clock.Add
+
+	return generator, clock
+}
+
+func TestNewSnowflakeGenerator_WithWorkerIdFrom(t *testing.T) {
+	generator, err := NewSnowflakeGenerator(&SnowflakeConfig{WorkerIdFrom: SnowflakeWorkerIdFromHostname})
+	assert.Nil(t, err)
+	expected, _ := snowflakeWorkerIdFromHostname(GetLocalHostname())
+	assert.Equal(t, expected, generator.WorkerId())
+
+	assert.Nil(t, os.Setenv("UT_WORKER_ID", "12"))
+	defer os.Unsetenv("UT_WORKER_ID")
+	generator, err = NewSnowflakeGenerator(&SnowflakeConfig{WorkerIdFrom: "ENV", WorkerIdEnv: "UT_WORKER_ID"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), generator.WorkerId())
+
+	// With invalid config
+	for _, config := range []*SnowflakeConfig{
+		{WorkerId: -1},
+		{WorkerId: 1024},
+		{WorkerIdFrom: "unknown"},
+		{WorkerIdFrom: SnowflakeWorkerIdFromEnv, WorkerIdEnv: "UT_ABSENT_WORKER_ID"},
+		{MaxClockRollback: "x"},
+		{MaxClockRollback: "-1s"},
+	} {
+		_, err = NewSnowflakeGenerator(config)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSnowflakeWorkerIdFromIP(t *testing.T) {
+	workerId, err := snowflakeWorkerIdFromIP("10.0.7.9")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3<<8|9), workerId)
+
+	_, err = snowflakeWorkerIdFromIP("localhost")
+	assert.NotNil(t, err)
+}
+
+func TestSnowflakeWorkerIdFromHostname(t *testing.T) {
+	// hash is stable and in range
+	first, _ := snowflakeWorkerIdFromHostname("web-abc")
+	second, _ := snowflakeWorkerIdFromHostname("web-abc")
+	assert.Equal(t, first, second)
+	assert.True(t, first >= 0 && first <= snowflakeMaxWorkerId)
+
+	// ordinal suffix is not used without opt-in
+	first, _ = snowflakeWorkerIdFromHostname("ip-10-0-1-12")
+	second, _ = snowflakeWorkerIdFromHostname("ip-10-0-2-12")
+	assert.NotEqual(t, first, second)
+
+	_, err := snowflakeWorkerIdFromHostname("")
+	assert.NotNil(t, err)
+}
+
+func TestSnowflakeWorkerIdFromOrdinal(t *testing.T) {
+	workerId, err := snowflakeWorkerIdFromOrdinal("web-3")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), workerId)
+
+	workerId, err = snowflakeWorkerIdFromOrdinal("my-db-0")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), workerId)
+
+	for _, hostname := range []string{"", "web", "web-abc", "Web-3", "web-03", "-3", "web-1024", "web.local-3"} {
+		_, err = snowflakeWorkerIdFromOrdinal(hostname)
+		assert.NotNil(t, err, hostname)
+	}
+}
+
+func TestSnowflakeGenerator_NextIdAndParse(t *testing.T) {
+	generator, clock := newTestSnowflakeGenerator(t, &SnowflakeConfig{WorkerId: 5})
+
+	first, err := generator.Generate()
+	assert.Nil(t, err)
+	second, err := generator.Generate()
+	assert.Nil(t, err)
+
+	res, err := ParseSnowflakeId(second)
+	assert.Nil(t, err)
+	assert.True(t, clock.Now().Equal(res.Time))
+	assert.Equal(t, int64(5), res.WorkerId)
+	assert.Equal(t, int64(1), res.Sequence)
+	assert.True(t, first < second)
+
+	// sequence exhausted, waits for the next millisecond
+	for i := 0; i < snowflakeMaxSeq-1; i++ {
+		_, err = generator.NextId()
+		assert.Nil(t, err)
+	}
+	id, _ := generator.Generate()
+	res, _ = ParseSnowflakeId(id)
+	assert.Equal(t, int64(0), res.Sequence)
+	assert.True(t, clock.Now().Equal(res.Time))
+	assert.Equal(t, time.Millisecond, res.Time.Sub(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	for _, invalid := range []string{"", "x", "-1"} {
+		_, err = ParseSnowflakeId(invalid)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSnowflakeGenerator_WithClockRollback(t *testing.T) {
+	generator, clock := newTestSnowflakeGenerator(t, &SnowflakeConfig{MaxClockRollback: "5ms"})
+
+	last, err := generator.NextId()
+	assert.Nil(t, err)
+
+	// waits for clock to catch up
+	clock.Add(-5 * time.Millisecond)
+	id, err := generator.NextId()
+	assert.Nil(t, err)
+	assert.True(t, id > last)
+
+	// returns error if rollback exceeds maxClockRollback
+	clock.Add(-time.Second)
+	_, err = generator.NextId()
+	assert.NotNil(t, err)
+
+	// always returns error
+	generator, clock = newTestSnowflakeGenerator(t, &SnowflakeConfig{MaxClockRollback: "0s"})
+	_, err = generator.NextId()
+	assert.Nil(t, err)
+	clock.Add(-time.Millisecond)
+	_, err = generator.NextId()
+	assert.NotNil(t, err)
+}
+
+func TestSnowflakeGenerator_Concurrent(t *testing.T) {
+	generator, err := NewSnowflakeGenerator(&SnowflakeConfig{WorkerId: 3})
+	assert.Nil(t, err)
+
+	lock := sync.Mutex{}
+	ids := make(map[int64]bool)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				id, _ := generator.NextId()
+				lock.Lock()
+				ids[id] = true
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, ids, 16000)
+}
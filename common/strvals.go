@@ -8,37 +8,174 @@ package rkcommon
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ErrNotList indicates that a non-list was treated as a list.
 var ErrNotList = errors.New("not a list")
 
+var (
+	// MaxIndex is the maximum index that will be allowed by setIndex.
+	// The default value 65536 = 1024 * 64
+	MaxIndex = 65536
+
+	// MaxNestedNameLevel is the maximum level of nesting for a key, counting both map and list accesses.
+	// The check would be disabled if value is less than 1.
+	MaxNestedNameLevel = 30
+)
+
+// OverrideSyntaxError describes a problem found while parsing a set line.
+type OverrideSyntaxError struct {
+	// Input is the whole set line
+	Input string
+	// Offset is the position in runes of Input where the error was detected
+	Offset int
+	// Fragment is the k/v section which contains the error
+	Fragment string
+	// Err is the underlying error
+	Err error
+}
+
+// Error returns the message along with offset and offending fragment.
+func (e *OverrideSyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d in %q", e.Err, e.Offset, e.Fragment)
+}
+
+// Unwrap returns the underlying error.
+func (e *OverrideSyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Caret renders the whole set line with a caret pointing to the position of the error, like:
+//
+// gin[0].port=2008,gin[x].enabled
+//                      ^
+func (e *OverrideSyntaxError) Caret() string {
+	// Replace blanks so that the caret stays aligned with the input.
+	line := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, e.Input)
+
+	return line + "\n" + strings.Repeat(" ", e.Offset) + "^"
+}
+
+// positionedError is an error detected at byte offset of input which differs from the current position of parser.
+type positionedError struct {
+	offset int
+	err    error
+}
+
+// Error returns message of the underlying error.
+func (e *positionedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *positionedError) Unwrap() error {
+	return e.err
+}
+
+// OverrideSyntaxErrors contains all errors found while parsing a set line.
+type OverrideSyntaxErrors []*OverrideSyntaxError
+
+// Error returns messages of all errors, one error per line.
+func (errs OverrideSyntaxErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
 // ParseBootConfigOverrides parses a set line.
 //
 // A set line is of the form name1=value1,name2=value2
+//
+// Error returned would be OverrideSyntaxErrors which contains every broken k/v section, the rest of sections
+// would still be parsed.
 func ParseBootConfigOverrides(s string) (map[interface{}]interface{}, error) {
 	vals := map[interface{}]interface{}{}
+	err := ParseBootConfigOverridesInto(s, vals)
+	return vals, err
+}
+
+// ParseBootConfigOverridesInto parses a set line and merges the result into dest.
+//
+// If a key is set more than once, the latter one wins.
+func ParseBootConfigOverridesInto(s string, dest map[interface{}]interface{}) error {
 	scanner := bytes.NewBufferString(s)
-	t := newParser(scanner, vals, false)
-	err := t.parse()
+	t := newParser(scanner, dest, false)
+	return t.parse()
+}
+
+// ParseBootConfigOverridesFile parses a set line, but its final value is loaded from the file at the path
+// specified by the original value. Relative path would be joined with current working directory.
+//
+// A set line is of the form name1=path1,name2=path2
+//
+// When the files at path1 and path2 contained "val1" and "val2" respectively, the set line is consumed as
+// name1=val1,name2=val2
+func ParseBootConfigOverridesFile(s string) (map[interface{}]interface{}, error) {
+	vals := map[interface{}]interface{}{}
+	err := ParseBootConfigOverridesFileInto(s, vals)
 	return vals, err
 }
 
+// ParseBootConfigOverridesFileInto parses a file set line and merges the result into dest.
+func ParseBootConfigOverridesFileInto(s string, dest map[interface{}]interface{}) error {
+	scanner := bytes.NewBufferString(s)
+	t := newFileParser(scanner, dest)
+	return t.parse()
+}
+
+// ParseBootConfigOverridesJSON parses a set line whose values are JSON documents.
+//
+// A set line is of the form name1=jsonval1,name2=jsonval2
+//
+// JSON objects are converted into map[interface{}]interface{} and integral numbers into int, so the result
+// could be merged with maps unmarshalled from YAML boot config.
+func ParseBootConfigOverridesJSON(s string) (map[interface{}]interface{}, error) {
+	vals := map[interface{}]interface{}{}
+	err := ParseBootConfigOverridesJSONInto(s, vals)
+	return vals, err
+}
+
+// ParseBootConfigOverridesJSONInto parses a JSON set line and merges the result into dest.
+func ParseBootConfigOverridesJSONInto(s string, dest map[interface{}]interface{}) error {
+	scanner := bytes.NewBufferString(s)
+	t := newJSONParser(scanner, dest)
+	return t.parse()
+}
+
 // parser is a simple parser that takes a strvals line and parses it into a
 // map representation.
 //
 // where sc is the source of the original data being parsed
 // where data is the final parsed data from the parses with correct types
 // where st is a boolean to figure out if we're forcing it to parse values as string
+// where isJSONVal is a boolean to figure out if values should be decoded as JSON documents
+// where input is the whole set line which is used to locate syntax errors
 type parser struct {
+	input      string
 	sc         *bytes.Buffer
 	data       map[interface{}]interface{}
 	runesToVal runesToVal
+	isJSONVal  bool
 }
 
 type runesToVal func([]rune) (interface{}, error)
@@ -47,19 +184,91 @@ func newParser(sc *bytes.Buffer, data map[interface{}]interface{}, stringBool bo
 	rs2v := func(rs []rune) (interface{}, error) {
 		return typedVal(rs, stringBool), nil
 	}
-	return &parser{sc: sc, data: data, runesToVal: rs2v}
+	return &parser{input: sc.String(), sc: sc, data: data, runesToVal: rs2v}
 }
 
+func newFileParser(sc *bytes.Buffer, data map[interface{}]interface{}) *parser {
+	rs2v := func(rs []rune) (interface{}, error) {
+		filePath := string(rs)
+		if !path.IsAbs(filePath) {
+			wd, err := os.Getwd()
+			if err != nil {
+				return "", err
+			}
+			filePath = path.Join(wd, filePath)
+		}
+
+		bytes, err := ioutil.ReadFile(filePath)
+		return string(bytes), err
+	}
+	return &parser{input: sc.String(), sc: sc, data: data, runesToVal: rs2v}
+}
+
+func newJSONParser(sc *bytes.Buffer, data map[interface{}]interface{}) *parser {
+	rs2v := func(rs []rune) (interface{}, error) {
+		return typedVal(rs, false), nil
+	}
+	return &parser{input: sc.String(), sc: sc, data: data, runesToVal: rs2v, isJSONVal: true}
+}
+
+// parse parses the whole set line. Instead of stopping at the first error, parser would skip the broken
+// k/v section and continue with the next one, all errors are returned as OverrideSyntaxErrors.
 func (t *parser) parse() error {
+	errs := make(OverrideSyntaxErrors, 0)
 	for {
-		err := t.key(t.data)
+		start := t.offset()
+		err := t.key(t.data, 0)
 		if err == nil {
 			continue
 		}
 		if err == io.EOF {
-			return nil
+			break
+		}
+
+		errs = append(errs, t.syntaxError(start, err))
+		if t.sc.Len() < 1 {
+			break
 		}
-		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// offset returns the byte offset of the next rune to read in input.
+func (t *parser) offset() int {
+	return len(t.input) - t.sc.Len()
+}
+
+// syntaxError builds OverrideSyntaxError of the k/v section starting at byte offset start and skips the rest of it.
+//
+// Error is located at the current position, or at the offset of positionedError if err wraps one.
+func (t *parser) syntaxError(start int, err error) *OverrideSyntaxError {
+	cur := t.offset()
+	pos := cur
+	var positioned *positionedError
+	if errors.As(err, &positioned) {
+		pos = positioned.offset
+	}
+
+	// The comma which terminates the section may have been consumed already.
+	end := cur
+	if cur <= start || t.input[cur-1] != ',' {
+		runesUntil(t.sc, runeSet([]rune{','}))
+		end = t.offset()
+	}
+	if end > start && end <= len(t.input) && t.input[end-1] == ',' {
+		end--
+	}
+
+	return &OverrideSyntaxError{
+		Input:    t.input,
+		Offset:   utf8.RuneCountInString(t.input[:pos]),
+		Fragment: t.input[start:end],
+		Err:      err,
 	}
 }
 
@@ -71,7 +280,7 @@ func runeSet(r []rune) map[rune]bool {
 	return s
 }
 
-func (t *parser) key(data map[interface{}]interface{}) error {
+func (t *parser) key(data map[interface{}]interface{}, nestedNameLevel int) error {
 	stop := runeSet([]rune{'=', '[', ',', '.'})
 	for {
 		switch k, last, err := runesUntil(t.sc, stop); {
@@ -86,20 +295,31 @@ func (t *parser) key(data map[interface{}]interface{}) error {
 			// We are in a list index context, so we need to set an index.
 			i, err := t.keyIndex()
 			if err != nil {
-				return fmt.Errorf("error parsing index: %s", err)
+				return fmt.Errorf("error parsing index: %w", err)
 			}
 			kk := string(k)
 			// Find or create target list
 			list := []interface{}{}
-			if _, ok := data[kk]; ok {
-				list = data[kk].([]interface{})
+			if existed, ok := data[kk]; ok && existed != nil {
+				if list, ok = existed.([]interface{}); !ok {
+					return fmt.Errorf("key %q is %w", kk, ErrNotList)
+				}
 			}
 
 			// Now we need to get the value after the ].
-			list, err = t.listItem(list, i)
+			list, err = t.listItem(list, i, nestedNameLevel)
 			set(data, kk, list)
 			return err
 		case last == '=':
+			if t.isJSONVal {
+				v, e := t.jsonVal()
+				if e != nil {
+					return e
+				}
+				set(data, string(k), v)
+				return nil
+			}
+
 			//End of key. Consume =, Get value.
 			// FIXME: Get value list first
 			vl, e := t.valList()
@@ -129,13 +349,22 @@ func (t *parser) key(data map[interface{}]interface{}) error {
 		case last == '.':
 			// First, create or find the target map.
 			inner := map[interface{}]interface{}{}
-			if _, ok := data[string(k)]; ok {
-				inner = data[string(k)].(map[interface{}]interface{})
+			if existed, ok := data[string(k)]; ok && existed != nil {
+				if inner, ok = existed.(map[interface{}]interface{}); !ok {
+					return fmt.Errorf("key %q is not a map", string(k))
+				}
+			}
+
+			if err := checkNestedNameLevel(nestedNameLevel + 1); err != nil {
+				return err
 			}
 
 			// Recurse
-			e := t.key(inner)
+			e := t.key(inner, nestedNameLevel+1)
 			if len(inner) == 0 {
+				if e != nil && e != io.EOF {
+					return e
+				}
 				return fmt.Errorf("key map %q has no value", string(k))
 			}
 			set(data, string(k), inner)
@@ -162,7 +391,10 @@ func setIndex(list []interface{}, index int, val interface{}) []interface{} {
 	return list
 }
 
+// keyIndex parses index before ']', errors are located at the start of index.
 func (t *parser) keyIndex() (int, error) {
+	start := t.offset()
+
 	// First, get the key.
 	stop := runeSet([]rune{']'})
 	v, _, err := runesUntil(t.sc, stop)
@@ -170,11 +402,32 @@ func (t *parser) keyIndex() (int, error) {
 		return 0, err
 	}
 	// v should be the index
-	return strconv.Atoi(string(v))
+	i, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, &positionedError{offset: start, err: err}
+	}
+
+	// Validate index before setIndex allocates the list, so that a typo could not exhaust memory.
+	if i < 0 {
+		return 0, &positionedError{offset: start, err: fmt.Errorf("negative %d index not allowed", i)}
+	}
+	if i > MaxIndex {
+		return 0, &positionedError{offset: start,
+			err: fmt.Errorf("index of %d is greater than maximum supported index %d", i, MaxIndex)}
+	}
+
+	return i, nil
+}
+
+func checkNestedNameLevel(nestedNameLevel int) error {
+	if MaxNestedNameLevel > 0 && nestedNameLevel > MaxNestedNameLevel {
+		return fmt.Errorf("value name nested level is greater than maximum supported nested level of %d", MaxNestedNameLevel)
+	}
 
+	return nil
 }
 
-func (t *parser) listItem(list []interface{}, i int) ([]interface{}, error) {
+func (t *parser) listItem(list []interface{}, i int, nestedNameLevel int) ([]interface{}, error) {
 	stop := runeSet([]rune{'[', '.', '='})
 	switch k, last, err := runesUntil(t.sc, stop); {
 	case len(k) > 0:
@@ -182,6 +435,14 @@ func (t *parser) listItem(list []interface{}, i int) ([]interface{}, error) {
 	case err != nil:
 		return list, err
 	case last == '=':
+		if t.isJSONVal {
+			v, e := t.jsonVal()
+			if e != nil {
+				return list, e
+			}
+			return setIndex(list, i, v), nil
+		}
+
 		vl, e := t.valList()
 		switch e {
 		case nil:
@@ -202,18 +463,25 @@ func (t *parser) listItem(list []interface{}, i int) ([]interface{}, error) {
 		// now we have a nested list. Read the index and handle.
 		nextI, err := t.keyIndex()
 		if err != nil {
-			return list, fmt.Errorf("error parsing index: %s", err)
+			return list, fmt.Errorf("error parsing index: %w", err)
 		}
 		var crtList []interface{}
 		if len(list) > i {
 			// If nested list already exists, take the value of list to next cycle.
 			existed := list[i]
 			if existed != nil {
-				crtList = list[i].([]interface{})
+				var ok bool
+				if crtList, ok = existed.([]interface{}); !ok {
+					return list, fmt.Errorf("index %d is %w", i, ErrNotList)
+				}
 			}
 		}
+		if err := checkNestedNameLevel(nestedNameLevel + 1); err != nil {
+			return list, err
+		}
+
 		// Now we need to get the value after the ].
-		list2, err := t.listItem(crtList, nextI)
+		list2, err := t.listItem(crtList, nextI, nestedNameLevel+1)
 		return setIndex(list, i, list2), err
 	case last == '.':
 		// We have a nested object. Send to t.key
@@ -228,8 +496,12 @@ func (t *parser) listItem(list []interface{}, i int) ([]interface{}, error) {
 			}
 		}
 
+		if err := checkNestedNameLevel(nestedNameLevel + 1); err != nil {
+			return list, err
+		}
+
 		// Recurse
-		e := t.key(inner)
+		e := t.key(inner, nestedNameLevel+1)
 		return setIndex(list, i, inner), e
 	default:
 		return nil, fmt.Errorf("parse error: unexpected token %v", last)
@@ -242,6 +514,111 @@ func (t *parser) val() ([]rune, error) {
 	return v, err
 }
 
+// jsonVal decodes a JSON document starting at the current position and consumes the following comma if any.
+//
+// Decoder has its own buffer which reads more runes than the ones actually decoded, so we decode from a copy of
+// what is left in t.sc and discard exactly the number of bytes consumed by the decoded value afterwards.
+//
+// Invalid value is skipped as a whole, so that commas inside of it would not be taken as separators of sections.
+func (t *parser) jsonVal() (interface{}, error) {
+	if t.emptyVal() {
+		return nil, nil
+	}
+
+	var val interface{}
+	start := t.offset()
+	dec := json.NewDecoder(strings.NewReader(t.sc.String()))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		pos := start
+		// Offset of json.SyntaxError includes the offending byte.
+		if syntaxErr, ok := err.(*json.SyntaxError); ok && syntaxErr.Offset > 0 {
+			pos += int(syntaxErr.Offset) - 1
+		}
+		t.skipJSONVal()
+		return nil, &positionedError{offset: pos, err: fmt.Errorf("invalid JSON value: %s", err)}
+	}
+	t.sc.Next(int(dec.InputOffset()))
+
+	if !t.emptyVal() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	return normalizeJSONVal(val), nil
+}
+
+// skipJSONVal skips runes until a comma outside of brackets and strings, the comma would be consumed.
+func (t *parser) skipJSONVal() {
+	depth, inString, escaped := 0, false, false
+	for {
+		r, _, err := t.sc.ReadRune()
+		if err != nil {
+			return
+		}
+
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				inString = false
+			}
+		case r == '"':
+			inString = true
+		case r == '{' || r == '[':
+			depth++
+		case r == '}' || r == ']':
+			if depth > 0 {
+				depth--
+			}
+		case r == ',' && depth < 1:
+			return
+		}
+	}
+}
+
+// emptyVal skips blanks and returns true if the value ends at the current position,
+// which means either a comma or end of input was reached. The comma would be consumed.
+func (t *parser) emptyVal() bool {
+	for {
+		r, _, e := t.sc.ReadRune()
+		if e != nil || r == ',' {
+			return true
+		}
+		if !unicode.IsSpace(r) {
+			t.sc.UnreadRune()
+			return false
+		}
+	}
+}
+
+// normalizeJSONVal converts decoded JSON value into types produced while unmarshalling YAML boot config.
+func normalizeJSONVal(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		res := make(map[interface{}]interface{}, len(v))
+		for k := range v {
+			res[k] = normalizeJSONVal(v[k])
+		}
+		return res
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeJSONVal(v[i])
+		}
+		return v
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
 func (t *parser) valList() ([]interface{}, error) {
 	r, _, e := t.sc.ReadRune()
 	if e != nil {
@@ -337,3 +714,146 @@ func typedVal(v []rune, st bool) interface{} {
 
 	return val
 }
+
+// FlattenToOverrides is the reverse of ParseBootConfigOverrides, it flattens a nested map into set lines of the form
+// a.b[0].c=v, one k/v section per element, which could be joined with comma and passed to --rkset.
+//
+// Keys and values are escaped so that the result could be parsed by ParseBootConfigOverrides into an equal map.
+// Keys of maps are visited in sorted order and elements of lists are visited in index order, so the result is
+// deterministic.
+//
+// Values which could not be represented in a set line would cause an error, including non string map keys,
+// empty maps and lists, floats and strings which would be parsed into other types like "true" or "1".
+// Integers are parsed back as int, unsigned integers larger than the max int would cause an error since they
+// would be parsed back as strings.
+func FlattenToOverrides(src map[interface{}]interface{}) ([]string, error) {
+	res := make([]string, 0)
+	if len(src) < 1 {
+		return res, nil
+	}
+
+	if err := flattenMap(&res, "", src); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// maxIntVal is the max value of int, which ParseBootConfigOverrides parses integers into.
+const maxIntVal = uint64(^uint(0) >> 1)
+
+func flattenVal(res *[]string, prefix string, val interface{}) error {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		return flattenMap(res, prefix, v)
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k := range v {
+			m[k] = v[k]
+		}
+		return flattenMap(res, prefix, m)
+	case []interface{}:
+		if len(v) < 1 {
+			return fmt.Errorf("key %q is an empty list", prefix)
+		}
+		for i := range v {
+			if err := flattenVal(res, prefix+"["+strconv.Itoa(i)+"]", v[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		*res = append(*res, prefix+"=null")
+		return nil
+	case bool:
+		*res = append(*res, prefix+"="+strconv.FormatBool(v))
+		return nil
+	case int, int8, int16, int32, int64, uint8, uint16:
+		*res = append(*res, fmt.Sprintf("%s=%d", prefix, v))
+		return nil
+	case uint:
+		return flattenUint(res, prefix, uint64(v))
+	case uint32:
+		return flattenUint(res, prefix, uint64(v))
+	case uint64:
+		return flattenUint(res, prefix, v)
+	case string:
+		if typed, ok := typedVal([]rune(v), false).(string); !ok || typed != v {
+			return fmt.Errorf("value of key %q would not be parsed as string: %q", prefix, v)
+		}
+		*res = append(*res, prefix+"="+escapeOverrideVal(v))
+		return nil
+	default:
+		return fmt.Errorf("value of key %q is unsupported type %T", prefix, val)
+	}
+}
+
+// flattenUint appends unsigned integer which could be parsed back as int.
+func flattenUint(res *[]string, prefix string, val uint64) error {
+	if val > maxIntVal {
+		return fmt.Errorf("value of key %q would not be parsed as int: %d", prefix, val)
+	}
+
+	*res = append(*res, prefix+"="+strconv.FormatUint(val, 10))
+	return nil
+}
+
+func flattenMap(res *[]string, prefix string, src map[interface{}]interface{}) error {
+	if len(src) < 1 {
+		return fmt.Errorf("key %q is an empty map", prefix)
+	}
+
+	keys := make([]string, 0, len(src))
+	for k := range src {
+		key, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("key %v of map %q is not a string", k, prefix)
+		}
+		if len(key) < 1 {
+			return fmt.Errorf("map %q contains empty key", prefix)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := escapeOverrideKey(k)
+		if len(prefix) > 0 {
+			name = prefix + "." + name
+		}
+
+		if err := flattenVal(res, name, src[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeOverrideKey escapes runes which would be treated as separators while parsing key.
+func escapeOverrideKey(key string) string {
+	return escapeRunes(key, runeSet([]rune{'\\', '=', '[', ',', '.'}))
+}
+
+// escapeOverrideVal escapes runes which would be treated as separators while parsing value.
+// Leading '{' is escaped as well, otherwise value would be parsed as list.
+func escapeOverrideVal(val string) string {
+	res := escapeRunes(val, runeSet([]rune{'\\', ','}))
+	if strings.HasPrefix(res, "{") {
+		res = "\\" + res
+	}
+
+	return res
+}
+
+func escapeRunes(s string, runes map[rune]bool) string {
+	var b strings.Builder
+	for _, r := range s {
+		if inMap(r, runes) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -6,8 +6,15 @@
 package rkcommon
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseBootConfigOverrides(t *testing.T) {
@@ -29,3 +36,262 @@ func TestParseBootConfigOverrides(t *testing.T) {
 	assert.Equal(t, "value1", res["key1"])
 	assert.Equal(t, "value0", res["slice"].([]interface{})[0])
 }
+
+func TestParseBootConfigOverridesFile(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "ut-temp.pem")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte("line1,\nline2.value"), 0777))
+
+	// With absolute path
+	res, err := ParseBootConfigOverridesFile("key1=" + filePath + ",slice[0]=" + filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "line1,\nline2.value", res["key1"])
+	assert.Equal(t, "line1,\nline2.value", res["slice"].([]interface{})[0])
+
+	// With relative path
+	res, err = ParseBootConfigOverridesFile("key1=strvals.go")
+	assert.Nil(t, err)
+	assert.Contains(t, res["key1"], "package rkcommon")
+
+	// With non exist file
+	_, err = ParseBootConfigOverridesFile("key1=non-exist.pem")
+	assert.NotNil(t, err)
+}
+
+func TestParseBootConfigOverridesJSON(t *testing.T) {
+	// For maps
+	res, err := ParseBootConfigOverridesJSON(`key1={"a":1,"b":[1.5,"c"]},key2="value2"`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[interface{}]interface{}{
+		"a": 1,
+		"b": []interface{}{1.5, "c"},
+	}, res["key1"])
+	assert.Equal(t, "value2", res["key2"])
+
+	// For slice and nested keys
+	res, err = ParseBootConfigOverridesJSON(`slice[1]=true,nested.key=null`)
+	assert.Nil(t, err)
+	assert.Equal(t, true, res["slice"].([]interface{})[1])
+	assert.Nil(t, res["nested"].(map[interface{}]interface{})["key"])
+
+	// With invalid JSON
+	_, err = ParseBootConfigOverridesJSON(`key1={"a":`)
+	assert.NotNil(t, err)
+
+	// With trailing data
+	_, err = ParseBootConfigOverridesJSON(`key1="a"b`)
+	assert.NotNil(t, err)
+}
+
+func TestParseBootConfigOverridesInto(t *testing.T) {
+	dest := map[interface{}]interface{}{}
+	assert.Nil(t, ParseBootConfigOverridesJSONInto(`key1={"a":1,"b":2}`, dest))
+	assert.Nil(t, ParseBootConfigOverridesInto("key1.a=3,key2=value2", dest))
+
+	assert.Equal(t, map[interface{}]interface{}{"a": 3, "b": 2}, dest["key1"])
+	assert.Equal(t, "value2", dest["key2"])
+}
+
+func TestParseBootConfigOverrides_WithSyntaxErrors(t *testing.T) {
+	res, err := ParseBootConfigOverrides("key1=value1,slice[x]=value,key2,key3=value3")
+	assert.NotNil(t, err)
+
+	// Valid sections should still be parsed
+	assert.Equal(t, "value1", res["key1"])
+	assert.Equal(t, "value3", res["key3"])
+
+	errs, ok := err.(OverrideSyntaxErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 2)
+
+	assert.Equal(t, "slice[x]=value", errs[0].Fragment)
+	assert.Equal(t, 18, errs[0].Offset)
+	assert.Contains(t, errs[0].Error(), "error parsing index")
+	assert.Equal(t, "key1=value1,slice[x]=value,key2,key3=value3\n"+
+		"                  ^", errs[0].Caret())
+
+	assert.Equal(t, "key2", errs[1].Fragment)
+	assert.Equal(t, 32, errs[1].Offset)
+	assert.Contains(t, errs[1].Error(), `key "key2" has no value`)
+
+	assert.Contains(t, err.Error(), `"slice[x]=value"`)
+	assert.Contains(t, err.Error(), `"key2"`)
+}
+
+func TestParseBootConfigOverridesJSON_WithInvalidValue(t *testing.T) {
+	res, err := ParseBootConfigOverridesJSON(`a={"x":1,,"y":2},b=3`)
+	assert.NotNil(t, err)
+
+	// Commas inside of invalid JSON value should not split sections
+	errs := err.(OverrideSyntaxErrors)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, `a={"x":1,,"y":2}`, errs[0].Fragment)
+	assert.Equal(t, 9, errs[0].Offset)
+	assert.Contains(t, errs[0].Error(), "invalid JSON value")
+	assert.Equal(t, 3, res["b"])
+}
+
+func TestParseBootConfigOverrides_WithMismatchedTypes(t *testing.T) {
+	_, err := ParseBootConfigOverrides("key1=value1,key1[0]=value2,key1.a=value3")
+	assert.NotNil(t, err)
+	assert.Len(t, err.(OverrideSyntaxErrors), 2)
+	assert.True(t, errors.Is(err.(OverrideSyntaxErrors)[0], ErrNotList))
+}
+
+func TestParseBootConfigOverrides_WithInvalidIndex(t *testing.T) {
+	// Negative index
+	_, err := ParseBootConfigOverrides("slice[-1]=value")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "negative -1 index not allowed")
+
+	// Index greater than MaxIndex
+	_, err = ParseBootConfigOverrides("slice[100000000]=value")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "greater than maximum supported index")
+	// Caret should point at the start of index
+	assert.Equal(t, 6, err.(OverrideSyntaxErrors)[0].Offset)
+
+	// Nested index greater than MaxIndex
+	_, err = ParseBootConfigOverrides("slice[0][100000000]=value")
+	assert.NotNil(t, err)
+
+	// With customized MaxIndex
+	defer func(origin int) { MaxIndex = origin }(MaxIndex)
+	MaxIndex = 1
+	_, err = ParseBootConfigOverrides("slice[2]=value")
+	assert.NotNil(t, err)
+	res, err := ParseBootConfigOverrides("slice[1]=value")
+	assert.Nil(t, err)
+	assert.Len(t, res["slice"], 2)
+}
+
+func TestParseBootConfigOverrides_WithMaxNestedNameLevel(t *testing.T) {
+	defer func(origin int) { MaxNestedNameLevel = origin }(MaxNestedNameLevel)
+	MaxNestedNameLevel = 2
+
+	res, err := ParseBootConfigOverrides("a.b.c=value")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, res)
+
+	_, err = ParseBootConfigOverrides("a.b.c.d=value")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "maximum supported nested level of 2")
+
+	_, err = ParseBootConfigOverrides("a[0][0][0][0]=value")
+	assert.NotNil(t, err)
+
+	_, err = ParseBootConfigOverrides("a[0].b[0].c[0].d=value")
+	assert.NotNil(t, err)
+
+	// Disable the check
+	MaxNestedNameLevel = 0
+	_, err = ParseBootConfigOverrides("a.b.c.d.e.f=value")
+	assert.Nil(t, err)
+}
+
+func TestFlattenToOverrides(t *testing.T) {
+	// With empty map
+	res, err := FlattenToOverrides(map[interface{}]interface{}{})
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	// With nested map and slice
+	res, err = FlattenToOverrides(map[interface{}]interface{}{
+		"gin": []interface{}{
+			map[interface{}]interface{}{
+				"port":          1949,
+				"commonService": map[string]interface{}{"enabled": true},
+			},
+		},
+		"a.b":   "c,d",
+		"empty": nil,
+		"list":  []interface{}{"{x}", int64(-1), uint64(2)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		`a\.b=c\,d`,
+		`empty=null`,
+		`gin[0].commonService.enabled=true`,
+		`gin[0].port=1949`,
+		`list[0]=\{x}`,
+		`list[1]=-1`,
+		`list[2]=2`,
+	}, res)
+
+	// With values which could not be represented
+	for _, v := range []interface{}{"true", "1", 1.5, uint64(math.MaxUint64), map[interface{}]interface{}{}, []interface{}{}, struct{}{}} {
+		_, err = FlattenToOverrides(map[interface{}]interface{}{"key": v})
+		assert.NotNil(t, err)
+	}
+	_, err = FlattenToOverrides(map[interface{}]interface{}{1: "value"})
+	assert.NotNil(t, err)
+}
+
+func TestFlattenToOverrides_RoundTrip(t *testing.T) {
+	const alphabet = `abcXYZ019-_ .,=[]{}\"'` + "\n世界"
+	// seed is logged, so that failures could be reproduced
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	randString := func() string {
+		rs := []rune(alphabet)
+		b := make([]rune, rnd.Intn(8)+1)
+		for i := range b {
+			b[i] = rs[rnd.Intn(len(rs))]
+		}
+		return string(b)
+	}
+
+	var randVal func(depth int) interface{}
+	randVal = func(depth int) interface{} {
+		kind := rnd.Intn(7)
+		if depth > 3 {
+			kind = rnd.Intn(5)
+		}
+
+		switch kind {
+		case 0:
+			return nil
+		case 1:
+			return rnd.Intn(2) == 0
+		case 2:
+			return rnd.Intn(20000) - 10000
+		case 3, 4:
+			// Strings which would be parsed into other types are not supported
+			s := randString()
+			if typed, ok := typedVal([]rune(s), false).(string); !ok || typed != s {
+				return "s" + s
+			}
+			return s
+		case 5:
+			list := make([]interface{}, rnd.Intn(3)+1)
+			for i := range list {
+				list[i] = randVal(depth + 1)
+			}
+			return list
+		default:
+			m := map[interface{}]interface{}{}
+			for i := rnd.Intn(3) + 1; i > 0; i-- {
+				m[randString()] = randVal(depth + 1)
+			}
+			return m
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		src := map[interface{}]interface{}{}
+		for j := rnd.Intn(4) + 1; j > 0; j-- {
+			src[randString()] = randVal(0)
+		}
+
+		lines, err := FlattenToOverrides(src)
+		assert.Nil(t, err)
+
+		res, err := ParseBootConfigOverrides(strings.Join(lines, ","))
+		assert.Nil(t, err)
+		if !assert.Equal(t, src, res) {
+			t.Logf("seed: %d, set line: %s", seed, strings.Join(lines, ","))
+			return
+		}
+	}
+}